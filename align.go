@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Минимальное количество треков для выравнивания камеры.
+	alignMinTracks = 100
+	// Максимальная невязка угла в градусах, при которой трек участвует в выравнивании.
+	alignMaxAngle = 10
+	// Максимальная невязка смещения в мм, при которой трек участвует в выравнивании.
+	alignMaxShift = 200
+	// Вес регуляризации поправок, не определяемых невязками.
+	alignRegularization = 1e-6
+)
+
+// alignPair содержит трек КТУДК и соответствующий ему трек ДЕКОР.
+type alignPair struct {
+	track geo.Line2
+	decor geo.Line3
+}
+
+// alignStats содержит среднее и среднеквадратичное отклонение невязок камеры.
+type alignStats struct {
+	n               int
+	meanAng, rmsAng float64
+	meanB, rmsB     float64
+}
+
+// alignCorrection содержит поправки положения камеры:
+// смещение в мм и углы поворота вокруг осей X, Y, Z системы НЕВОД в радианах.
+type alignCorrection [6]float64
+
+func (c *alignCorrection) apply(pts [3]geo.Vec3) [3]geo.Vec3 {
+	center := pts[0].Add(pts[1]).Add(pts[2]).Mul(1.0 / 3)
+	shift := geo.Vec3{X: c[0], Y: c[1], Z: c[2]}
+	for i := range pts {
+		pts[i] = rotate(pts[i].Sub(center), c[3], c[4], c[5]).Add(center).Add(shift)
+	}
+	return pts
+}
+
+// rotate поворачивает v вокруг осей X, Y и Z на углы a, b и g соответственно.
+func rotate(v geo.Vec3, a, b, g float64) geo.Vec3 {
+	v = geo.Vec3{X: v.X, Y: v.Y*math.Cos(a) - v.Z*math.Sin(a), Z: v.Y*math.Sin(a) + v.Z*math.Cos(a)}
+	v = geo.Vec3{X: v.X*math.Cos(b) + v.Z*math.Sin(b), Y: v.Y, Z: -v.X*math.Sin(b) + v.Z*math.Cos(b)}
+	v = geo.Vec3{X: v.X*math.Cos(g) - v.Y*math.Sin(g), Y: v.X*math.Sin(g) + v.Y*math.Cos(g), Z: v.Z}
+	return v
+}
+
+func alignResiduals(chamber *trek.Chamber, p *alignPair) (float64, float64) {
+	dTrack := chamber.LineProjection(p.decor)
+	dang := toAng(math.Atan(p.track.K())) - toAng(math.Atan(dTrack.K()))
+	return dang, p.track.B() - dTrack.B()
+}
+
+func mkAlignStats(desc trek.ChamberDesc, pairs []alignPair) alignStats {
+	chamber := trek.NewChamber(desc)
+	var s alignStats
+	for i := range pairs {
+		dang, db := alignResiduals(chamber, &pairs[i])
+		s.meanAng += dang
+		s.rmsAng += dang * dang
+		s.meanB += db
+		s.rmsB += db * db
+		s.n++
+	}
+	if s.n != 0 {
+		n := float64(s.n)
+		s.meanAng, s.meanB = s.meanAng/n, s.meanB/n
+		s.rmsAng, s.rmsB = math.Sqrt(s.rmsAng/n), math.Sqrt(s.rmsB/n)
+	}
+	return s
+}
+
+// alignChamber находит поправки положения камеры desc, минимизирующие невязки pairs.
+func alignChamber(desc trek.ChamberDesc, pairs []alignPair) alignCorrection {
+	before := mkAlignStats(desc, pairs)
+	sigmaAng, sigmaB := math.Max(before.rmsAng, 1e-3), math.Max(before.rmsB, 1e-3)
+	points := desc.Points
+	cost := func(x []float64) float64 {
+		var c alignCorrection
+		copy(c[:], x)
+		desc.Points = c.apply(points)
+		chamber := trek.NewChamber(desc)
+		var sum float64
+		for i := range pairs {
+			dang, db := alignResiduals(chamber, &pairs[i])
+			sum += dang*dang/(sigmaAng*sigmaAng) + db*db/(sigmaB*sigmaB)
+		}
+		for _, v := range x {
+			sum += alignRegularization * v * v
+		}
+		return sum
+	}
+	x, _ := geo.Minimize(cost, make([]float64, 6), []float64{10, 10, 10, 1e-3, 1e-3, 1e-3}, 20000, 1e-12)
+	var c alignCorrection
+	copy(c[:], x)
+	return c
+}
+
+// align выравнивает камеры по трекам ДЕКОР из ранов runs и записывает исправленную конфигурацию.
+func align(runs []int) error {
+	if err := os.MkdirAll(*outDir, 0777); err != nil {
+		return fmt.Errorf("Failed create output dir: %s", err)
+	}
+	config, ok, failed, err := readRunsChamberConfig(runs, readChamberConfig)
+	if err != nil {
+		return err
+	}
+	pairs := make(map[int][]alignPair)
+	for _, run := range ok {
+		log.Println("Processing ", run)
+		if err := collectAlignPairs(run, config, pairs); err != nil {
+			log.Println("Failed:", err)
+			failed = append(failed, run)
+		} else {
+			log.Println("Success")
+		}
+	}
+
+	report, err := os.Create(outputPath("align_report.dat"))
+	if err != nil {
+		return fmt.Errorf("Failed create report file: %s", err)
+	}
+	defer report.Close()
+	fmt.Fprintf(report, "# %8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\n",
+		"chamber", "tracks", "dang", "rms", "db", "rms", "dang'", "rms'", "db'", "rms'",
+		"dx", "dy", "dz", "rx", "ry", "rz")
+	for i := range config {
+		desc := &config[i]
+		chamPairs := pairs[desc.Number]
+		if len(chamPairs) < alignMinTracks {
+			log.Printf("Chamber %d: not enough tracks (%d)\n", desc.Number+1, len(chamPairs))
+			continue
+		}
+		before := mkAlignStats(*desc, chamPairs)
+		c := alignChamber(*desc, chamPairs)
+		desc.Points = c.apply(desc.Points)
+		after := mkAlignStats(*desc, chamPairs)
+		fmt.Fprintf(report, "%10d\t%8d\t%8.3f\t%8.3f\t%8.3f\t%8.3f\t%8.3f\t%8.3f\t%8.3f\t%8.3f\t",
+			desc.Number+1, before.n, before.meanAng, before.rmsAng, before.meanB, before.rmsB,
+			after.meanAng, after.rmsAng, after.meanB, after.rmsB)
+		fmt.Fprintf(report, "%8.3f\t%8.3f\t%8.3f\t%8.5f\t%8.5f\t%8.5f\n", c[0], c[1], c[2], c[3], c[4], c[5])
+	}
+	restoreConfig(config)
+	if err := writeRawChamberConfig(outputPath("chambers.conf.aligned"), config); err != nil {
+		return fmt.Errorf("Failed write chamber config: %s", err)
+	}
+	return failedRunsError(failed, len(runs))
+}
+
+// collectAlignPairs собирает из рана run пары треков КТУДК и ДЕКОР для каждой камеры config.
+// Пары добавляются в pairs, только если ран прочитан полностью.
+func collectAlignPairs(run int, config []trek.ChamberDesc, pairs map[int][]alignPair) error {
+	chambers := make(map[int]*trek.Chamber)
+	for _, desc := range config {
+		chambers[desc.Number] = trek.NewChamber(desc)
+	}
+	f, r, err := openExtData(run)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	runPairs := make(map[int][]alignPair)
+	var record trek.ExtEvent
+	for {
+		if err := r.Read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed read extctudc.tds: %s", err)
+		}
+		for cham, times := range record.Ctudc.Times() {
+			chamber, ok := chambers[cham]
+			if !ok {
+				continue
+			}
+			for _, dEvent := range record.Decor {
+				if !chamber.Hexahendron().Crossing(dEvent.Track) {
+					continue
+				}
+				cTrack := chamber.CreateTrack(times)
+				if cTrack == nil {
+					continue
+				}
+				p := alignPair{track: cTrack.Line, decor: dEvent.Track}
+				if dang, db := alignResiduals(chamber, &p); math.Abs(dang) < alignMaxAngle && math.Abs(db) < alignMaxShift {
+					runPairs[cham] = append(runPairs[cham], p)
+				}
+			}
+		}
+	}
+	for cham, p := range runPairs {
+		pairs[cham] = append(pairs[cham], p...)
+	}
+	return nil
+}
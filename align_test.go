@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+func TestAlignChamber(t *testing.T) {
+	nominal := trek.ChamberDesc{
+		Points: [3]geo.Vec3{{X: 0, Y: 0, Z: 0}, {X: 500, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 4000}},
+		Speeds: [4]float64{0.25, 0.25, 0.25, 0.25},
+		Wires:  trek.DefaultWires,
+	}
+	// Истинное положение камеры отличается от номинального смещением и поворотом.
+	shift := alignCorrection{5, 3, 0, 0.001, 0, 0.004}
+	actual := nominal
+	actual.Points = shift.apply(nominal.Points)
+	chamber := trek.NewChamber(actual)
+
+	rnd := rand.New(rand.NewSource(1))
+	var pairs []alignPair
+	for i := 0; i < 500; i++ {
+		decor := geo.Line3{
+			Point:  geo.Vec3{X: 250, Y: 100 * (rnd.Float64() - 0.5), Z: 4000 * rnd.Float64()},
+			Vector: geo.Vec3{X: 1, Y: rnd.Float64() - 0.5, Z: rnd.Float64() - 0.5},
+		}
+		pairs = append(pairs, alignPair{track: chamber.LineProjection(decor), decor: decor})
+	}
+
+	c := alignChamber(nominal, pairs)
+	for i, tol := range []float64{1, 0.1, math.Inf(1), 1e-4, math.Inf(1), 1e-4} {
+		if math.Abs(c[i]-shift[i]) > tol {
+			t.Errorf("correction %v, expected %v", c, shift)
+			break
+		}
+	}
+	aligned := nominal
+	aligned.Points = c.apply(nominal.Points)
+	if s := mkAlignStats(aligned, pairs); s.rmsAng > 0.01 || s.rmsB > 0.1 {
+		t.Errorf("residuals after alignment: %+v", s)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Количество узлов таблицы r(t).
+	calibNodes = 64
+	// Количество итераций уточнения r(t) по невязкам треков.
+	calibIterations = 8
+	// Минимальное количество измерений в узле для его коррекции.
+	calibMinEntries = 20
+)
+
+// chamberCalib содержит данные для калибровки одной камеры.
+type chamberCalib struct {
+	desc    trek.ChamberDesc
+	samples []trek.TrackTimes
+}
+
+func calibrate(runs []int) error {
+	if err := os.MkdirAll(*outDir, 0777); err != nil {
+		return fmt.Errorf("Failed create output dir: %s", err)
+	}
+	chams := make(map[int]*chamberCalib)
+	var failed []int
+	for _, run := range runs {
+		log.Println("Processing ", run)
+		if err := collectCalibSamples(run, chams); err != nil {
+			log.Println("Failed:", err)
+			failed = append(failed, run)
+		} else {
+			log.Println("Success")
+		}
+	}
+	var numbers []int
+	for cham := range chams {
+		numbers = append(numbers, cham)
+	}
+	sort.Ints(numbers)
+
+	calib := make(trek.Calibration)
+	for _, cham := range numbers {
+		c := chams[cham]
+		rt := c.initialRT()
+		if rt == nil {
+			log.Printf("Chamber %d: not enough data\n", cham+1)
+			continue
+		}
+		for i := 0; i < calibIterations; i++ {
+			rms, n := c.iterate(rt)
+			log.Printf("Chamber %d iteration %d: tracks %d rms %f\n", cham+1, i+1, n, rms)
+		}
+		calib[cham+1] = rt
+	}
+
+	f, err := os.Create(outputPath("chambers.rt"))
+	if err != nil {
+		return fmt.Errorf("Failed create calibration file: %s", err)
+	}
+	defer f.Close()
+	if err := calib.Write(f); err != nil {
+		return fmt.Errorf("Failed write calibration: %s", err)
+	}
+	return failedRunsError(failed, len(runs))
+}
+
+// collectCalibSamples собирает из рана run измерения камер,
+// в которых на каждой проволке ровно одно срабатывание.
+func collectCalibSamples(run int, chams map[int]*chamberCalib) error {
+	config, err := readChamberConfig(formatChamberConfig(run))
+	if err != nil {
+		return fmt.Errorf("Failed read chamber config: %s", err)
+	}
+	chambers := make(map[int]*trek.Chamber)
+	for _, desc := range config {
+		chambers[desc.Number] = trek.NewChamber(desc)
+		if chams[desc.Number] == nil {
+			chams[desc.Number] = &chamberCalib{desc: desc}
+		}
+	}
+	f, r, err := openExtData(run)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Измерения добавляются к калибровке, только если ран прочитан полностью.
+	samples := make(map[int][]trek.TrackTimes)
+	var record trek.ExtEvent
+	for {
+		if err := r.Read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed read extctudc.tds: %s", err)
+		}
+		for cham, times := range record.Ctudc.Times() {
+			chamber, ok := chambers[cham]
+			if !ok || !singleHits(times) || chamber.TimesDepth(times) != 1 {
+				continue
+			}
+			var sample trek.TrackTimes
+			for wire := range times {
+				sample[wire] = times[wire][0]
+			}
+			samples[cham] = append(samples[cham], sample)
+		}
+	}
+	for cham, s := range samples {
+		chams[cham].samples = append(chams[cham].samples, s...)
+	}
+	return nil
+}
+
+func singleHits(times *trek.ChamTimes) bool {
+	for _, t := range times {
+		if len(t) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// initialRT строит начальные таблицы r(t) по интегралу спектра времен дрейфа
+// в предположении равномерной засветки камеры.
+func (c *chamberCalib) initialRT() *[4]trek.RTable {
+	if len(c.samples) < calibMinEntries {
+		return nil
+	}
+	var rt [4]trek.RTable
+	chamber := trek.NewChamber(c.desc)
+	rmax := chamber.Width() / 2
+	for wire := range rt {
+		speed := c.desc.Speeds[wire]
+		if speed <= 0 {
+			return nil
+		}
+		start := c.desc.Offsets[wire]
+		stop := start + uint(rmax/speed)
+		rt[wire] = trek.NewRTable(start, stop, calibNodes)
+		counts := make([]float64, calibNodes)
+		for _, s := range c.samples {
+			if bin, ok := rt[wire].Bin(s[wire]); ok {
+				counts[bin+1]++
+			}
+		}
+		var total float64
+		for _, n := range counts {
+			total += n
+		}
+		if total == 0 {
+			return nil
+		}
+		var sum float64
+		for i, n := range counts {
+			sum += n
+			rt[wire].Dists[i] = rmax * sum / total
+		}
+	}
+	return &rt
+}
+
+// iterate уточняет таблицы rt по средним невязкам треков в узлах.
+// Возвращает среднеквадратичную невязку и количество восстановленных треков.
+func (c *chamberCalib) iterate(rt *[4]trek.RTable) (float64, int) {
+	desc := c.desc
+	desc.RT = rt
+	chamber := trek.NewChamber(desc)
+	rmax := chamber.Width() / 2
+
+	var sums, weights [4][calibNodes]float64
+	var sumSq float64
+	var tracks int
+	for _, s := range c.samples {
+		times := trek.ChamTimes{{s[0]}, {s[1]}, {s[2]}, {s[3]}}
+		track := chamber.CreateTrack(&times)
+		if track == nil {
+			continue
+		}
+		tracks++
+		res := chamber.Residuals(track)
+		for wire := range res {
+			bin, ok := rt[wire].Bin(s[wire])
+			if !ok {
+				continue
+			}
+			t0 := rt[wire].Start + uint(bin)*rt[wire].Step
+			frac := float64(s[wire]-t0) / float64(rt[wire].Step)
+			sums[wire][bin] += (1 - frac) * res[wire]
+			weights[wire][bin] += 1 - frac
+			sums[wire][bin+1] += frac * res[wire]
+			weights[wire][bin+1] += frac
+			sumSq += res[wire] * res[wire]
+		}
+	}
+	for wire := range rt {
+		dists := rt[wire].Dists
+		for i := range dists {
+			if weights[wire][i] >= calibMinEntries {
+				dists[i] += sums[wire][i] / weights[wire][i]
+			}
+			dists[i] = math.Min(math.Max(dists[i], 0), rmax)
+			if i > 0 && dists[i] < dists[i-1] {
+				dists[i] = dists[i-1]
+			}
+		}
+	}
+	if tracks == 0 {
+		return 0, 0
+	}
+	return math.Sqrt(sumSq / float64(4*tracks)), tracks
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/frostoov/CtudcHandler/trek"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressions перечисляет методы сжатия и расширения, добавляемые к именам сжатых файлов.
+var compressions = []struct {
+	name string
+	ext  string
+}{
+	{"gzip", ".gz"},
+	{"zstd", ".zst"},
+}
+
+// checkCompression проверяет допустимость метода сжатия name.
+func checkCompression(name string) error {
+	if name == "none" || compressedExt(name) != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid compression %q", name)
+}
+
+// compressedExt возвращает расширение файлов, сжатых методом name, или пустую строку.
+func compressedExt(name string) string {
+	for _, c := range compressions {
+		if c.name == name {
+			return c.ext
+		}
+	}
+	return ""
+}
+
+// splitCompressedExt возвращает имя файла filename без расширения сжатия и метод сжатия.
+func splitCompressedExt(filename string) (string, string) {
+	for _, c := range compressions {
+		if strings.HasSuffix(filename, c.ext) {
+			return strings.TrimSuffix(filename, c.ext), c.name
+		}
+	}
+	return filename, "none"
+}
+
+// newCompressor возвращает поток, сжимающий данные методом name в w.
+func newCompressor(w io.Writer, name string) (io.WriteCloser, error) {
+	if name == "zstd" {
+		return zstd.NewWriter(w)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// dataWriter записывает данные во временный файл, при необходимости сжимая их.
+// Файл получает окончательное имя только при успешном Close.
+type dataWriter struct {
+	*bufio.Writer
+	f      *os.File
+	zw     io.WriteCloser
+	name   string
+	stale  []string
+	closed bool
+}
+
+// createData создает файл данных filename. Если compression не "none",
+// данные сжимаются и к имени добавляется расширение метода сжатия.
+// После успешного Close файлы с тем же именем, но другим способом сжатия удаляются.
+func createData(filename, compression string) (*dataWriter, error) {
+	name := filename + compressedExt(compression)
+	var stale []string
+	if name != filename {
+		stale = append(stale, filename)
+	}
+	for _, c := range compressions {
+		if filename+c.ext != name {
+			stale = append(stale, filename+c.ext)
+		}
+	}
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &dataWriter{f: f, name: name, stale: stale}
+	if compression != "none" {
+		if w.zw, err = newCompressor(f, compression); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+		w.Writer = bufio.NewWriter(w.zw)
+	} else {
+		w.Writer = bufio.NewWriter(f)
+	}
+	return w, nil
+}
+
+// Compressed сообщает, сжимаются ли записываемые данные.
+func (w *dataWriter) Compressed() bool {
+	return w.zw != nil
+}
+
+// Name возвращает имя создаваемого файла.
+func (w *dataWriter) Name() string {
+	return w.name
+}
+
+// Close дописывает данные и переименовывает временный файл.
+// При ошибке временный файл удаляется.
+func (w *dataWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.Flush()
+	if w.zw != nil {
+		if zErr := w.zw.Close(); err == nil {
+			err = zErr
+		}
+	}
+	if fErr := w.f.Close(); err == nil {
+		err = fErr
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), w.name)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	for _, stale := range w.stale {
+		if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed remove %s: %s\n", stale, err)
+		}
+	}
+	return nil
+}
+
+// Abort удаляет временный файл, не изменяя существующие данные. После Close ничего не делает.
+func (w *dataWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// findData возвращает имя существующего файла данных filename, возможно сжатого.
+func findData(filename string) (string, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+	for _, c := range compressions {
+		if _, err := os.Stat(filename + c.ext); err == nil {
+			return filename + c.ext, nil
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+}
+
+// dataReader читает распакованные данные файла.
+type dataReader struct {
+	io.Reader
+	f *os.File
+}
+
+// openData открывает файл данных filename и распаковывает его, если он сжат.
+func openData(filename string) (*dataReader, error) {
+	return openDataAt(filename, 0)
+}
+
+// openDataAt открывает файл данных filename с позиции offset распакованного потока.
+func openDataAt(filename string, offset int64) (*dataReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := isCompressed(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !compressed {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &dataReader{Reader: f, f: f}, nil
+	}
+	r, err := trek.Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	// Сжатый поток не допускает произвольного доступа: данные до offset распаковываются и отбрасываются.
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dataReader{Reader: r, f: f}, nil
+}
+
+func (r *dataReader) Close() error {
+	return r.f.Close()
+}
+
+// isCompressed проверяет по первым байтам, сжат ли файл f, и возвращается к его началу.
+func isCompressed(f *os.File) (bool, error) {
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return trek.IsCompressed(magic[:n]), nil
+}
+
+// compressFile сжимает файл filename методом compression, проверяет результат и удаляет исходный файл.
+// В структурированный заголовок файла добавляется признак trek.FlagCompressed.
+func compressFile(filename, compression string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if compressed, err := isCompressed(src); err != nil {
+		return err
+	} else if compressed {
+		return nil
+	}
+	name := filename + compressedExt(compression)
+	tmpname := name + ".tmp"
+	dst, err := os.Create(tmpname)
+	if err != nil {
+		return err
+	}
+	zw, err := newCompressor(dst, compression)
+	if err == nil {
+		err = copyCompressed(zw, bufio.NewReader(src))
+		if zErr := zw.Close(); err == nil {
+			err = zErr
+		}
+	}
+	if cErr := dst.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = verifyCompressed(filename, tmpname)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	if err := os.Rename(tmpname, name); err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// copyCompressed копирует данные r в w, добавляя в заголовок признак trek.FlagCompressed.
+func copyCompressed(w io.Writer, r *bufio.Reader) error {
+	header, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if _, err := io.WriteString(w, trek.AddHeaderFlags(header, trek.FlagCompressed)); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// verifyCompressed проверяет, что распакованный файл compressed совпадает с файлом original.
+// Заголовки файлов должны различаться только признаком trek.FlagCompressed.
+func verifyCompressed(original, compressed string) error {
+	a, err := os.Open(original)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := openData(compressed)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	ra, rb := bufio.NewReader(a), bufio.NewReader(b)
+	headerA, err := ra.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	headerB, err := rb.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("verification of %s failed: %s", compressed, err)
+	}
+	if trek.AddHeaderFlags(headerA, trek.FlagCompressed) != headerB {
+		return fmt.Errorf("verification of %s failed: headers differ", compressed)
+	}
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return fmt.Errorf("verification of %s failed: data differ", compressed)
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			if errB != errA {
+				return fmt.Errorf("verification of %s failed: sizes differ", compressed)
+			}
+			return nil
+		}
+		if errA != nil {
+			return errA
+		}
+		if errB != nil {
+			return fmt.Errorf("verification of %s failed: %s", compressed, errB)
+		}
+	}
+}
+
+// compressRun сжимает файлы КТУДК и объединенные данные рана run методом compression.
+func compressRun(run int, compression string) error {
+	var files []string
+	if set, err := readFileSet(formatCtudcSubdir(run), ".tds"); err == nil {
+		files = set.Paths()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if extname, err := findData(formatExtFilename(run)); err == nil {
+		files = append(files, extname)
+	}
+	for _, filename := range files {
+		if _, comp := splitCompressedExt(filename); comp != "none" {
+			continue
+		}
+		log.Println("Compressing ", filename)
+		if err := compressFile(filename, compression); err != nil {
+			return fmt.Errorf("Failed compress %s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// compress сжимает данные ранов runs на месте методом compression.
+func compress(runs []int, compression string) error {
+	if compression == "none" {
+		return fmt.Errorf("invalid compression %q", compression)
+	}
+	if err := checkCompression(compression); err != nil {
+		return err
+	}
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Processing ", formatRunDir(run))
+		return nil, compressRun(run, compression)
+	}, func(*runResult) error {
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+func TestCompressFile(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	data := testRecords(seq(1, 10)...)
+	for _, compression := range []string{"gzip", "zstd"} {
+		filename := writeRepairFile(t, testHeader(), data)
+		if err := compressFile(filename, compression); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s: original file is kept: %v", compression, err)
+		}
+		r, err := openData(filename + compressedExt(compression))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := bytes.IndexByte(content, '\n')
+		if i < 0 {
+			t.Fatalf("%s: no header", compression)
+		}
+		h, err := trek.ParseHeader(string(content[:i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Flags&trek.FlagCompressed == 0 || h.Run != testRun {
+			t.Errorf("%s: header %+v", compression, h)
+		}
+		if !bytes.Equal(content[i+1:], data) {
+			t.Errorf("%s: data differ", compression)
+		}
+	}
+}
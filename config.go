@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/template"
+)
+
+// Переменные окружения, переопределяющие конфигурацию.
+const (
+	envConfig   = "CTUDC_CONFIG"
+	envCtudc    = "CTUDC_ROOT"
+	envNevod    = "NEVOD_ROOT"
+	envRunDir   = "CTUDC_RUN_DIR"
+	envCtudcDir = "CTUDC_CTUDC_DIR"
+	envNevodDir = "CTUDC_NEVOD_DIR"
+	envOut      = "CTUDC_OUT"
+)
+
+// Шаблоны путей по умолчанию.
+const (
+	defaultRunDir   = `run_{{printf "%05d" .Run}}`
+	defaultCtudcDir = `ctudc_{{printf "%05d" .Run}}`
+	defaultNevodDir = `NAD_{{printf "%03d" .Run}}`
+)
+
+// appConfig содержит расположение данных.
+// Шаблоны путей записываются в синтаксисе text/template и получают номер рана .Run,
+// корни .CtudcRoot и .NevodRoot и, кроме шаблона run_dir, директорию рана .RunDir.
+// Относительный путь директории рана отсчитывается от CtudcRoot,
+// директории КТУДК - от директории рана, директории НЕВОД - от NevodRoot.
+type appConfig struct {
+	CtudcRoot string `json:"ctudc_root"`
+	NevodRoot string `json:"nevod_root"`
+	RunDir    string `json:"run_dir"`
+	CtudcDir  string `json:"ctudc_dir"`
+	NevodDir  string `json:"nevod_dir"`
+
+	runDir, ctudcDir, nevodDir *template.Template
+}
+
+var appConf = defaultAppConfig()
+
+// defaultAppConfig возвращает конфигурацию по умолчанию: данные в текущей директории.
+func defaultAppConfig() appConfig {
+	conf := appConfig{
+		RunDir:   defaultRunDir,
+		CtudcDir: defaultCtudcDir,
+		NevodDir: defaultNevodDir,
+	}
+	conf.compile()
+	return conf
+}
+
+// defaultConfigPath возвращает путь к файлу конфигурации по умолчанию.
+func defaultConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return "CtudcHandler.conf"
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "ctudc", "CtudcHandler.conf")
+}
+
+// readAppConfig читает конфигурацию из файла confpath и применяет переопределения из окружения.
+// Если confpath пуст, читается файл по умолчанию; его отсутствие не является ошибкой.
+func readAppConfig(confpath string) (appConfig, error) {
+	conf := defaultAppConfig()
+	optional := confpath == ""
+	if optional {
+		confpath = defaultConfigPath()
+	}
+	data, err := ioutil.ReadFile(confpath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return conf, fmt.Errorf("Failed unmarshal %s: %s", confpath, err)
+		}
+	case !optional || !os.IsNotExist(err):
+		return conf, fmt.Errorf("Failed read config: %s", err)
+	}
+	for env, field := range map[string]*string{
+		envCtudc:    &conf.CtudcRoot,
+		envNevod:    &conf.NevodRoot,
+		envRunDir:   &conf.RunDir,
+		envCtudcDir: &conf.CtudcDir,
+		envNevodDir: &conf.NevodDir,
+	} {
+		if value, ok := os.LookupEnv(env); ok {
+			*field = value
+		}
+	}
+	if err := conf.compile(); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// compile разбирает шаблоны путей и проверяет их выполнение.
+func (c *appConfig) compile() error {
+	for _, t := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"run_dir", c.RunDir, &c.runDir},
+		{"ctudc_dir", c.CtudcDir, &c.ctudcDir},
+		{"nevod_dir", c.NevodDir, &c.nevodDir},
+	} {
+		tmpl, err := template.New(t.name).Parse(t.text)
+		if err != nil {
+			return fmt.Errorf("Invalid %s template: %s", t.name, err)
+		}
+		if _, err := c.execute(tmpl, 0, ""); err != nil {
+			return fmt.Errorf("Invalid %s template: %s", t.name, err)
+		}
+		*t.dst = tmpl
+	}
+	return nil
+}
+
+// pathData содержит значения, доступные шаблонам путей.
+type pathData struct {
+	Run       int
+	CtudcRoot string
+	NevodRoot string
+	RunDir    string
+}
+
+func (c *appConfig) execute(tmpl *template.Template, run int, runDir string) (string, error) {
+	data := pathData{Run: run, CtudcRoot: c.CtudcRoot, NevodRoot: c.NevodRoot, RunDir: runDir}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// resolve выполняет шаблон tmpl для рана run с директорией рана runDir
+// и отсчитывает относительный путь от base.
+// Шаблоны проверены при загрузке конфигурации, поэтому ошибки выполнения не ожидаются.
+func (c *appConfig) resolve(tmpl *template.Template, base string, run int, runDir string) string {
+	p, _ := c.execute(tmpl, run, runDir)
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(base, p)
+}
+
+func (c *appConfig) formatRunDir(run int) string {
+	return c.resolve(c.runDir, c.CtudcRoot, run, "")
+}
+
+func (c *appConfig) formatCtudcSubdir(run int) string {
+	runDir := c.formatRunDir(run)
+	return c.resolve(c.ctudcDir, runDir, run, runDir)
+}
+
+func (c *appConfig) formatNevodRunDir(run int) string {
+	return c.resolve(c.nevodDir, c.NevodRoot, run, c.formatRunDir(run))
+}
+
+func formatRunDir(run int) string {
+	return appConf.formatRunDir(run)
+}
+
+func formatChamberConfig(run int) string {
+	return filepath.Join(formatRunDir(run), "chambers.conf.new")
+}
+
+func formatCtudcFilename(run, fileno int) string {
+	ctudcSubdir := formatCtudcSubdir(run)
+	return filepath.Join(ctudcSubdir, fmt.Sprintf("ctudc_%05d_%08d.tds", run, fileno))
+}
+
+// formatExtFilename возвращает имя несжатого файла объединенных данных рана run.
+func formatExtFilename(run int) string {
+	return filepath.Join(formatRunDir(run), fmt.Sprintf("extctudc_%05d.tds", run))
+}
+
+func formatCtudcSubdir(run int) string {
+	return appConf.formatCtudcSubdir(run)
+}
+
+func formatNevodRunDir(run int) string {
+	return appConf.formatNevodRunDir(run)
+}
+
+// outputPath возвращает путь elem в директории результатов команды.
+func outputPath(elem ...string) string {
+	return filepath.Join(append([]string{*outDir}, elem...)...)
+}
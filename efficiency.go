@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Количество бинов карты эффективности по длине камеры.
+	effPosBins = 20
+	// Количество бинов карты эффективности по углу.
+	effAngBins = 12
+	// Диапазон углов карты эффективности в градусах.
+	effMaxAngle = 60
+)
+
+// chamberEfficiency содержит карты ожидаемых и зарегистрированных прохождений треков ДЕКОР через камеру.
+type chamberEfficiency struct {
+	expected *histogram2
+	hits     [4]*histogram2
+	good     [4]*histogram2
+	tracks   *histogram2
+}
+
+func newChamberEfficiency(length float64) *chamberEfficiency {
+	mk := func() *histogram2 {
+		return newHistogram2(0, length, effPosBins, -effMaxAngle, effMaxAngle, effAngBins)
+	}
+	e := &chamberEfficiency{
+		expected: mk(),
+		tracks:   mk(),
+	}
+	for wire := range e.hits {
+		e.hits[wire] = mk()
+		e.good[wire] = mk()
+	}
+	return e
+}
+
+// add прибавляет к e карты other той же камеры.
+func (e *chamberEfficiency) add(other *chamberEfficiency) {
+	e.expected.add(other.expected)
+	e.tracks.add(other.tracks)
+	for wire := range e.hits {
+		e.hits[wire].add(other.hits[wire])
+		e.good[wire].add(other.good[wire])
+	}
+}
+
+// efficiency строит карты эффективности камер и проволок по трекам ДЕКОР из ранов runs.
+func efficiency(runs []int) error {
+	outdir := outputPath("efficiency")
+	if err := os.MkdirAll(outdir, 0777); err != nil {
+		return fmt.Errorf("Failed create output dir: %s", err)
+	}
+	effs := make(map[int]*chamberEfficiency)
+	var failed []int
+	for _, run := range runs {
+		log.Println("Processing ", run)
+		if err := fillEfficiency(run, effs); err != nil {
+			log.Println("Failed:", err)
+			failed = append(failed, run)
+		} else {
+			log.Println("Success")
+		}
+	}
+	var numbers []int
+	for cham := range effs {
+		numbers = append(numbers, cham)
+	}
+	sort.Ints(numbers)
+
+	summary, err := os.Create(path.Join(outdir, "summary.dat"))
+	if err != nil {
+		return fmt.Errorf("Failed create summary file: %s", err)
+	}
+	defer summary.Close()
+	fmt.Fprintf(summary, "# %8s\t%8s\t%8s\t%8s\t%8s\t%8s\n", "chamber", "wire", "expected", "hit", "good", "track")
+	for _, cham := range numbers {
+		e := effs[cham]
+		if err := writeEfficiencyMap(path.Join(outdir, fmt.Sprintf("chamber_%03d.dat", cham+1)), e); err != nil {
+			return fmt.Errorf("Failed write efficiency map: %s", err)
+		}
+		expected, tracks := sumBins(e.expected), sumBins(e.tracks)
+		for wire := range e.hits {
+			fmt.Fprintf(summary, "%10d\t%8d\t%8.0f\t%8.4f\t%8.4f\t%8.4f\n", cham+1, wire+1, expected,
+				ratio(sumBins(e.hits[wire]), expected), ratio(sumBins(e.good[wire]), expected), ratio(tracks, expected))
+		}
+	}
+	return failedRunsError(failed, len(runs))
+}
+
+// fillEfficiency заполняет карты effs по рану run. Карты дополняются, только если ран прочитан полностью.
+func fillEfficiency(run int, effs map[int]*chamberEfficiency) error {
+	chambers, err := readRunChambers(run)
+	if err != nil {
+		return fmt.Errorf("Failed read chamber config: %s", err)
+	}
+	runEffs := make(map[int]*chamberEfficiency)
+	for cham, chamber := range chambers {
+		runEffs[cham] = newChamberEfficiency(chamber.Length())
+	}
+	f, r, err := openExtData(run)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var record trek.ExtEvent
+	for {
+		if err := r.Read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed read extctudc.tds: %s", err)
+		}
+		times := record.Ctudc.Times()
+		for cham, chamber := range chambers {
+			// Учитываются только события с единственным треком ДЕКОР через камеру.
+			var crossing []trek.DecorTrack
+			for _, dEvent := range record.Decor {
+				if chamber.Hexahendron().Crossing(dEvent.Track) {
+					crossing = append(crossing, dEvent)
+				}
+			}
+			if len(crossing) != 1 {
+				continue
+			}
+			pos, ok := chamber.CrossingPosition(crossing[0].Track)
+			if !ok {
+				continue
+			}
+			dTrack := chamber.LineProjection(crossing[0].Track)
+			ang := toAng(math.Atan(dTrack.K()))
+			e := runEffs[cham]
+			e.expected.fill(pos, ang)
+			chamTimes := times[cham]
+			if chamTimes == nil {
+				continue
+			}
+			for wire := range chamTimes {
+				if len(chamTimes[wire]) > 0 {
+					e.hits[wire].fill(pos, ang)
+				}
+				good := 0
+				for _, t := range chamTimes[wire] {
+					if _, ok := chamber.DriftDist(wire, t); ok {
+						good++
+					}
+				}
+				if good == 1 {
+					e.good[wire].fill(pos, ang)
+				}
+			}
+			if len(chamber.CreateTracks(chamTimes)) > 0 {
+				e.tracks.fill(pos, ang)
+			}
+		}
+	}
+	for cham, e := range runEffs {
+		if effs[cham] == nil {
+			effs[cham] = e
+		} else {
+			effs[cham].add(e)
+		}
+	}
+	return nil
+}
+
+func writeEfficiencyMap(filename string, e *chamberEfficiency) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# %8s\t%8s\t%8s", "pos", "ang", "expected")
+	for wire := range e.hits {
+		fmt.Fprintf(w, "\thit[%d]\tgood[%d]", wire+1, wire+1)
+	}
+	fmt.Fprintf(w, "\t%8s\n", "track")
+	for i := range e.expected.bins {
+		for j := range e.expected.bins[i] {
+			expected := e.expected.bins[i][j]
+			fmt.Fprintf(w, "%10.1f\t%8.1f\t%8.0f", e.expected.x.center(i), e.expected.y.center(j), expected)
+			for wire := range e.hits {
+				fmt.Fprintf(w, "\t%8.4f\t%8.4f", ratio(e.hits[wire].bins[i][j], expected), ratio(e.good[wire].bins[i][j], expected))
+			}
+			fmt.Fprintf(w, "\t%8.4f\n", ratio(e.tracks.bins[i][j], expected))
+		}
+	}
+	return w.Flush()
+}
+
+func sumBins(h *histogram2) float64 {
+	var sum float64
+	for i := range h.bins {
+		for _, n := range h.bins[i] {
+			sum += n
+		}
+	}
+	return sum
+}
+
+func ratio(n, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return n / total
+}
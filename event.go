@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// showEvent выводит событие с номером nevent рана run.
+func showEvent(runs []int, nevent uint) error {
+	if len(runs) != 1 {
+		return errors.New("exactly one run must be specified")
+	}
+	r, err := openRunReader(runs[0], false)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := r.Seek(nevent); err != nil {
+		return fmt.Errorf("Failed seek event %d: %s", nevent, err)
+	}
+	var e trek.ExtEvent
+	if err := r.Read(&e); err != nil {
+		return fmt.Errorf("Failed read event %d: %s", nevent, err)
+	}
+	printEvent(os.Stdout, &e, r.ext)
+	return nil
+}
+
+// printEvent выводит хиты, метаданные НЕВОД и треки ДЕКОР события e.
+func printEvent(w io.Writer, e *trek.ExtEvent, withNevod bool) {
+	fmt.Fprintf(w, "run %d event %d time %s\n", e.Ctudc.Nrun(), e.Ctudc.Nevent(), e.Ctudc.Time().UTC().Format(monitorTimeLayout))
+	fmt.Fprintf(w, "hits: %d\n", len(e.Ctudc.Hits()))
+	fmt.Fprintf(w, "  %7s\t%4s\t%8s\t%8s\n", "chamber", "wire", "type", "time")
+	for _, h := range e.Ctudc.Hits() {
+		fmt.Fprintf(w, "  %7d\t%4d\t%8v\t%8d\n", h.Chamber()+1, h.Wire()+1, h.Type(), h.Time())
+	}
+	if !withNevod {
+		fmt.Fprintln(w, "no NEVOD data")
+		return
+	}
+	m := &e.Nevod
+	fmt.Fprintf(w, "nevod: event %d trigger %#04x nlam %d fifo A/B/C %d/%d/%d\n",
+		m.Nevent, m.TrigNvd, m.Nlam, m.NfifoA, m.NfifoB, m.NfifoC)
+	if e.Full != nil {
+		fmt.Fprintf(w, "deposit: d12 %.1f d9 %.1f\n", e.Full.Deposit[0], e.Full.Deposit[1])
+	}
+	fmt.Fprintf(w, "decor tracks: %d\n", len(e.Decor))
+	for _, t := range e.Decor {
+		kind := "long"
+		if t.Type != 0 {
+			kind = "shsh"
+		}
+		p, v := t.Track.Point, t.Track.Vector
+		fmt.Fprintf(w, "  %4s\tpoint (%.1f, %.1f, %.1f)\tvector (%.4f, %.4f, %.4f)\tzenith %.2f\tazimuth %.2f\n",
+			kind, p.X, p.Y, p.Z, v.X, v.Y, v.Z, toAng(trek.Zenith(v)), toAng(trek.Azimuth(v)))
+	}
+}
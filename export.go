@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	path "path/filepath"
+	"time"
+
+	"github.com/frostoov/CtudcHandler/nevod"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// exportHit содержит хит КТУДК в формате экспорта.
+type exportHit struct {
+	Chamber int    `json:"chamber"`
+	Wire    int    `json:"wire"`
+	Time    uint   `json:"time"`
+	Type    string `json:"type"`
+}
+
+// exportTrack содержит трек ДЕКОР в формате экспорта.
+type exportTrack struct {
+	Type   string     `json:"type"`
+	Point  [3]float64 `json:"point"`
+	Vector [3]float64 `json:"vector"`
+}
+
+// exportEvent содержит событие в формате экспорта, одна строка NDJSON.
+type exportEvent struct {
+	Run   uint             `json:"run"`
+	Event uint             `json:"event"`
+	Time  time.Time        `json:"time"`
+	Hits  []exportHit      `json:"hits"`
+	Nevod *nevod.EventMeta `json:"nevod,omitempty"`
+	Decor []exportTrack    `json:"decor,omitempty"`
+	// Энерговыделение в кодах АЦП 12 и 9 динодов
+	Deposit *[2]float64 `json:"deposit,omitempty"`
+}
+
+func mkExportEvent(e *trek.Event) exportEvent {
+	event := exportEvent{
+		Run:   e.Nrun(),
+		Event: e.Nevent(),
+		Time:  e.Time(),
+		Hits:  make([]exportHit, 0, len(e.Hits())),
+	}
+	for _, h := range e.Hits() {
+		event.Hits = append(event.Hits, exportHit{
+			Chamber: h.Chamber() + 1,
+			Wire:    h.Wire() + 1,
+			Time:    h.Time(),
+			Type:    h.Type().String(),
+		})
+	}
+	return event
+}
+
+func mkExportExtEvent(e *trek.ExtEvent) exportEvent {
+	event := mkExportEvent(&e.Ctudc)
+	meta := e.Nevod
+	event.Nevod = &meta
+	if e.Full != nil {
+		deposit := e.Full.Deposit
+		event.Deposit = &deposit
+	}
+	for _, t := range e.Decor {
+		trackType := "long"
+		if t.Type == 1 {
+			trackType = "shsh"
+		}
+		event.Decor = append(event.Decor, exportTrack{
+			Type:   trackType,
+			Point:  [3]float64{t.Track.Point.X, t.Track.Point.Y, t.Track.Point.Z},
+			Vector: [3]float64{t.Track.Vector.X, t.Track.Vector.Y, t.Track.Vector.Z},
+		})
+	}
+	return event
+}
+
+// exportFile записывает события из файла filename в w в формате NDJSON.
+// Поддерживаются файлы КТУДК и объединенные файлы extctudc.
+func exportFile(filename string, w io.Writer) error {
+	f, err := openData(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Failed read header: %s", err)
+	}
+	file, err := trek.ParseHeader(header)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	switch file.Format {
+	case trek.FormatExt:
+		er, err := newExtReader(r, header)
+		if err != nil {
+			return err
+		}
+		var record trek.ExtEvent
+		for {
+			if err := er.Read(&record); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := enc.Encode(mkExportExtEvent(&record)); err != nil {
+				return err
+			}
+		}
+	default:
+		var event trek.Event
+		for {
+			if err := event.Unmarshal(r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := enc.Encode(mkExportEvent(&event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// export выводит события из файлов, соответствующих patterns, в stdout в формате NDJSON.
+func export(patterns []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, pattern := range patterns {
+		filenames, err := path.Glob(pattern)
+		if err != nil {
+			log.Printf("Failed handle pattern %s %s\n", pattern, err)
+			continue
+		}
+		for _, filename := range filenames {
+			log.Println("Exporting: ", filename)
+			if err := exportFile(filename, w); err != nil {
+				return fmt.Errorf("%s: %s", filename, err)
+			}
+		}
+	}
+	return nil
+}
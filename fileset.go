@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	path "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var digitsRegexp = regexp.MustCompile(`\d+`)
+
+// seqFile содержит файл набора и его номер в последовательности.
+type seqFile struct {
+	name string
+	// Номер рана, если имя содержит две группы цифр, иначе -1
+	run int
+	// Порядковый номер файла (последняя группа цифр имени), иначе -1
+	seq int
+}
+
+// parseSeqFile извлекает номер рана и порядковый номер из имени файла name,
+// например ctudc_%05d_%08d.tds или 00000012.nad.
+func parseSeqFile(name string) seqFile {
+	f := seqFile{name: name, run: -1, seq: -1}
+	base, _ := splitCompressedExt(name)
+	groups := digitsRegexp.FindAllString(strings.TrimSuffix(base, path.Ext(base)), -1)
+	if n := len(groups); n != 0 {
+		f.seq, _ = strconv.Atoi(groups[n-1])
+		if n > 1 {
+			f.run, _ = strconv.Atoi(groups[n-2])
+		}
+	}
+	return f
+}
+
+// fileSet содержит файлы директории с заданным расширением, упорядоченные по номерам рана и последовательности.
+type fileSet struct {
+	dir   string
+	files []seqFile
+}
+
+// readFileSet читает список файлов с расширением ext из директории dirname.
+// Сжатые файлы с расширением ext и расширением метода сжатия также входят в набор.
+func readFileSet(dirname, ext string) (*fileSet, error) {
+	fileList, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	set := &fileSet{dir: dirname}
+	for _, fileStat := range fileList {
+		name, _ := splitCompressedExt(fileStat.Name())
+		if fileStat.IsDir() || !strings.EqualFold(path.Ext(name), ext) {
+			continue
+		}
+		set.files = append(set.files, parseSeqFile(fileStat.Name()))
+	}
+	sort.Slice(set.files, func(i, j int) bool {
+		a, b := &set.files[i], &set.files[j]
+		if a.run != b.run {
+			return a.run < b.run
+		}
+		if a.seq != b.seq {
+			return a.seq < b.seq
+		}
+		return a.name < b.name
+	})
+	return set, nil
+}
+
+// Paths возвращает пути к файлам набора по порядку.
+func (s *fileSet) Paths() []string {
+	paths := make([]string, len(s.files))
+	for i := range s.files {
+		paths[i] = path.Join(s.dir, s.files[i].name)
+	}
+	return paths
+}
+
+// Gaps возвращает описания пропущенных порядковых номеров файлов набора.
+func (s *fileSet) Gaps() []string {
+	var gaps []string
+	for i := 1; i < len(s.files); i++ {
+		prev, cur := &s.files[i-1], &s.files[i]
+		if prev.seq < 0 || prev.run != cur.run || cur.seq <= prev.seq+1 {
+			continue
+		}
+		if cur.seq == prev.seq+2 {
+			gaps = append(gaps, fmt.Sprintf("%d", prev.seq+1))
+		} else {
+			gaps = append(gaps, fmt.Sprintf("%d-%d", prev.seq+1, cur.seq-1))
+		}
+	}
+	return gaps
+}
+
+// logGaps выводит в лог пропущенные файлы набора.
+func (s *fileSet) logGaps() {
+	if gaps := s.Gaps(); len(gaps) != 0 {
+		log.Printf("Missing files in %s: %s\n", s.dir, strings.Join(gaps, ", "))
+	}
+}
+
+// Open открывает файлы набора для чтения как одного непрерывного потока.
+func (s *fileSet) Open() (*fileSetReader, error) {
+	r := &fileSetReader{paths: s.Paths()}
+	var start int64
+	for _, filename := range r.paths {
+		stat, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		r.starts = append(r.starts, start)
+		start += stat.Size()
+	}
+	r.size = start
+	return r, nil
+}
+
+// fileSetReader читает последовательность файлов как один поток.
+// Реализует io.ReadSeeker; позиция отсчитывается от начала первого файла.
+type fileSetReader struct {
+	paths  []string
+	starts []int64
+	size   int64
+
+	offset int64
+	cur    int
+	f      *os.File
+}
+
+// file возвращает индекс файла, содержащего позицию offset.
+func (r *fileSetReader) file(offset int64) int {
+	return sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > offset }) - 1
+}
+
+func (r *fileSetReader) Read(p []byte) (int, error) {
+	for {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		if r.f == nil {
+			r.cur = r.file(r.offset)
+			f, err := os.Open(r.paths[r.cur])
+			if err != nil {
+				return 0, err
+			}
+			log.Println("Opening file: ", path.Base(r.paths[r.cur]))
+			if _, err := f.Seek(r.offset-r.starts[r.cur], io.SeekStart); err != nil {
+				f.Close()
+				return 0, err
+			}
+			r.f = f
+		}
+		n, err := r.f.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			r.f.Close()
+			r.f = nil
+			// Файл мог оказаться короче, чем при открытии набора.
+			end := r.size
+			if r.cur+1 < len(r.starts) {
+				end = r.starts[r.cur+1]
+			}
+			if r.offset < end {
+				r.offset = end
+			}
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *fileSetReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("fileset: negative position %d", offset)
+	}
+	if r.f != nil {
+		if offset < r.size && r.file(offset) == r.cur {
+			if _, err := r.f.Seek(offset-r.starts[r.cur], io.SeekStart); err != nil {
+				return r.offset, err
+			}
+		} else {
+			r.f.Close()
+			r.f = nil
+		}
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close закрывает текущий файл потока.
+func (r *fileSetReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
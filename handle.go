@@ -5,48 +5,126 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	path "path/filepath"
+	"reflect"
+	"runtime/debug"
+	"sort"
 
 	geo "github.com/frostoov/CtudcHandler/math"
+	"github.com/frostoov/CtudcHandler/nevod"
 	"github.com/frostoov/CtudcHandler/trek"
 )
 
-var validHandlers = map[string]bool{
-	"TDS_ext\n":  true,
-	"TDSext_m\n": true,
+// Название программы в заголовках создаваемых файлов.
+const producerName = "CtudcHandler"
+
+// Версия программы, задаваемая при сборке: go build -ldflags "-X main.version=1.2.0".
+var version string
+
+// producer возвращает название и версию программы для заголовков создаваемых файлов.
+// Если версия не задана при сборке, используется ревизия исходного кода из информации о сборке.
+func producer() string {
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				v = s.Value[:12]
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	return producerName + "/" + v
+}
+
+// parseExtHeader разбирает строку заголовка объединенного файла.
+func parseExtHeader(header string) (*trek.FileHeader, error) {
+	file, err := trek.ParseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if file.Format != trek.FormatExt {
+		return nil, fmt.Errorf("%s data is not merged events", file.Format)
+	}
+	return file, nil
+}
+
+// extReader читает события объединенного файла extctudc.
+type extReader struct {
+	r      *bufio.Reader
+	file   *trek.FileHeader
+	header trek.ExtHeader
+	// Файл содержит полные данные НЕВОД
+	full bool
+}
+
+// newExtReader читает из r заголовок файла после строки header и возвращает читатель событий.
+func newExtReader(r *bufio.Reader, header string) (*extReader, error) {
+	file, err := parseExtHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	er := &extReader{r: r, file: file, full: file.Flags&trek.FlagFullNevod != 0}
+	// Версия 1 не содержит ExtHeader.
+	if file.Version >= 2 {
+		if err := er.header.Unmarshal(r); err != nil {
+			return nil, fmt.Errorf("Failed read ext header: %s", err)
+		}
+	}
+	return er, nil
+}
+
+// Read читает следующее событие в e.
+func (r *extReader) Read(e *trek.ExtEvent) error {
+	if r.full {
+		return e.UnmarshalFull(r.r)
+	}
+	e.Full = nil
+	return e.Unmarshal(r.r)
 }
 
 type Handler struct {
 	chambers    map[int]*trek.Chamber
-	tracksFiles map[int]*os.File
+	tracks      trackWriter
 	loadFile    *os.File
+	tracks3File *os.File
 }
 
-func formatTracksHeader() string {
-	var buf bytes.Buffer
-	for i := 0; i < 4; i++ {
-		fmt.Fprintf(&buf, "WIRE_%03d\t", i+1)
-	}
-	fmt.Fprintf(&buf, "%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s",
-		"k1", "k2", "dev", "ang[C]", "b[C]", "ang[D]", "b[D]", "dang", "db")
-	return buf.String()
+func formatTracks3Header() string {
+	return fmt.Sprintf("%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s",
+		"event", "cham1", "cham2", "cosX", "cosY", "cosZ", "zen[C]", "az[C]", "zen[D]", "az[D]", "dang", "dist")
 }
 
-func NewHandler() (*Handler, error) {
-	if err := os.MkdirAll("output/tracks", 0777); err != nil {
+func NewHandler(format string) (*Handler, error) {
+	if err := os.MkdirAll(outputPath("tracks"), 0777); err != nil {
 		return nil, fmt.Errorf("Failed create output dir: %s", err)
 	}
-	loadFile, err := os.Create("output/load.dat")
+	tracks, err := newTrackWriter(format)
 	if err != nil {
+		return nil, err
+	}
+	loadFile, err := os.Create(outputPath("load.dat"))
+	if err != nil {
+		tracks.Close()
 		return nil, fmt.Errorf("Failed create load file: %s", err)
 	}
+	tracks3File, err := os.Create(outputPath("tracks3d.dat"))
+	if err != nil {
+		tracks.Close()
+		loadFile.Close()
+		return nil, fmt.Errorf("Failed create 3d tracks file: %s", err)
+	}
+	fmt.Fprintln(tracks3File, "#", formatTracks3Header())
 	return &Handler{
-		tracksFiles: make(map[int]*os.File),
+		tracks:      tracks,
 		loadFile:    loadFile,
+		tracks3File: tracks3File,
 	}, nil
 }
 
@@ -54,147 +132,345 @@ func (h *Handler) Close() {
 	if h.loadFile != nil {
 		h.loadFile.Close()
 	}
-	for _, f := range h.tracksFiles {
-		f.Close()
+	if h.tracks3File != nil {
+		h.tracks3File.Close()
+	}
+	if h.tracks != nil {
+		if err := h.tracks.Close(); err != nil {
+			log.Println("Failed close track output:", err)
+		}
 	}
 }
 
-func (h *Handler) Handle(runs []int) error {
-	for _, run := range runs {
+// runOutput содержит результаты обработки одного рана до записи в выходные файлы.
+type runOutput struct {
+	load    bytes.Buffer
+	tracks  []trackRecord
+	tracks3 bytes.Buffer
+}
+
+// Handle обрабатывает раны runs, до jobs ранов одновременно.
+// Результаты записываются в выходные файлы в порядке runs.
+func (h *Handler) Handle(runs []int, jobs int) error {
+	failed := processRuns(runs, jobs, func(run int) (interface{}, error) {
 		log.Println("Processing ", run)
-		if err := h.handleRun(run); err != nil {
-			log.Println("Failed:", err)
-		} else {
-			log.Println("Success")
+		return h.handleRun(run)
+	}, func(r *runResult) error {
+		return h.writeOutput(r.output.(*runOutput))
+	})
+	return failedRunsError(failed, len(runs))
+}
+
+func (h *Handler) writeOutput(out *runOutput) error {
+	if _, err := h.loadFile.Write(out.load.Bytes()); err != nil {
+		return err
+	}
+	for i := range out.tracks {
+		if err := h.tracks.Write(&out.tracks[i]); err != nil {
+			return err
 		}
 	}
+	if _, err := h.tracks3File.Write(out.tracks3.Bytes()); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (h *Handler) handleRun(run int) error {
-	root := formatRunDir(run)
-	chambers, err := readChambers(path.Join(root, "/chambers.conf.new"))
+func (h *Handler) handleRun(run int) (*runOutput, error) {
+	chambers, err := readRunChambers(run)
 	if err != nil {
-		return fmt.Errorf("Failed read chamber config: %s", err)
+		return nil, fmt.Errorf("Failed read chamber config: %s", err)
 	}
-	f, err := os.Open(path.Join(root, fmt.Sprintf("extctudc_%05d.tds", run)))
+	f, r, err := openExtData(run)
 	if err != nil {
-		return fmt.Errorf("Failed open extctudc.tds: %s", err)
+		return nil, err
 	}
 	defer f.Close()
-	r := bufio.NewReader(f)
-	if header, err := r.ReadString('\n'); err != nil || !validHandlers[header] {
-		return fmt.Errorf("Invalid header of extctudc.tds %s", header)
-	} else if header == "TDSext_m\n" {
-		new(trek.ExtHeader).Unmarshal(r)
-	}
+	out := new(runOutput)
 	var record trek.ExtEvent
-	for record.Unmarshal(r) == nil {
+	for {
+		if err := r.Read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed read extctudc.tds: %s", err)
+		}
 		times := record.Ctudc.Times()
+		tracks := make(map[int][]trek.TrackDesc)
+		var numbers []int
+		for cham, times := range times {
+			if chamber, ok := chambers[cham]; ok {
+				tracks[cham] = chamber.CreateTracks(times)
+				numbers = append(numbers, cham)
+			}
+		}
+		sort.Ints(numbers)
 		// 1. Загрузка
 		var loadChams uint
 		var muons uint
-		for cham, times := range times {
-			if chamber, ok := chambers[cham]; ok {
-				depth := chamber.TimesDepth(times)
-				muons += uint(depth)
-				if depth > 0 {
-					loadChams++
-				}
+		for _, cTracks := range tracks {
+			muons += uint(len(cTracks))
+			if len(cTracks) > 0 {
+				loadChams++
 			}
 		}
 		if muons > 1 {
-			fmt.Fprintf(h.loadFile, "%d\t%d\t%d\t%d\t%d\n", loadChams, muons, record.Ctudc.Nevent(), len(record.Decor), record.Nevod.NfifoC)
+			fmt.Fprintf(&out.load, "%d\t%d\t%d\t%d\t%d", loadChams, muons, record.Ctudc.Nevent(), len(record.Decor), record.Nevod.NfifoC)
+			// Энерговыделение есть только в данных, объединенных с -full.
+			if record.Full != nil {
+				deposit := record.Full.Deposit
+				fmt.Fprintf(&out.load, "\t%.1f\t%.1f", deposit[nevod.Dinode12], deposit[nevod.Dinode9])
+			}
+			fmt.Fprintln(&out.load)
 		}
 		// 2. Углы
-		for cham, times := range times {
-			chamber, ok := chambers[cham]
-			if !ok {
-				continue
-			}
+		for _, cham := range numbers {
+			chamber, cTracks := chambers[cham], tracks[cham]
 			for _, dEvent := range record.Decor {
-				if !chamber.Hexahendron().Crossing(dEvent.Track) {
-					continue
-				}
-				cTrack := chamber.CreateTrack(times)
-				if cTrack == nil {
+				if len(cTracks) == 0 || !chamber.Hexahendron().Crossing(dEvent.Track) {
 					continue
 				}
-				if h.tracksFiles[cham] == nil {
-					f, err := os.Create(fmt.Sprintf("output/tracks/chamber_%03d.dat", cham+1))
-					if err != nil {
-						log.Fatalln("Failed create track file:", err)
-					}
-					if _, err := fmt.Fprintln(f, "#", formatTracksHeader()); err != nil {
-						log.Fatalln("Failed write track header:", err)
-					}
-
-					h.tracksFiles[cham] = f
-				}
-				f := h.tracksFiles[cham]
 				dTrack := chamber.LineProjection(dEvent.Track)
-				k1 := int(cTrack.Times[0] - cTrack.Times[1] - cTrack.Times[2] + cTrack.Times[3])
-				k2 := int(cTrack.Times[0] - 3*cTrack.Times[1] + 3*cTrack.Times[2] - cTrack.Times[3])
-				dAng := toAng(math.Atan(dTrack.K()))
-				cAng := toAng(math.Atan(cTrack.Line.K()))
-				fmt.Fprintf(f, "%8d\t%8d\t%8d\t%8d\t", cTrack.Times[0], cTrack.Times[1], cTrack.Times[2], cTrack.Times[3])
-				fmt.Fprintf(f, "%8d\t%8d\t", k1, k2)
-				fmt.Fprintf(f, "%8f\t%8f\t%8f\t", cTrack.Deviation, cAng, cTrack.Line.B())
-				fmt.Fprintf(f, "%8f\t%8f\t", dAng, dTrack.B())
-				fmt.Fprintf(f, "%8f\t%8f\n", cAng-dAng, cTrack.Line.B()-dTrack.B())
+				cTrack := closestTrack(cTracks, dTrack)
+				tr := trackRecord{
+					run:     uint(run),
+					event:   record.Ctudc.Nevent(),
+					chamber: cham,
+					times:   cTrack.Times,
+					k1:      int(cTrack.Times[0] - cTrack.Times[1] - cTrack.Times[2] + cTrack.Times[3]),
+					k2:      int(cTrack.Times[0] - 3*cTrack.Times[1] + 3*cTrack.Times[2] - cTrack.Times[3]),
+					dev:     cTrack.Deviation,
+					cAng:    toAng(math.Atan(cTrack.Line.K())),
+					cB:      cTrack.Line.B(),
+					dAng:    toAng(math.Atan(dTrack.K())),
+					dB:      dTrack.B(),
+				}
+				out.tracks = append(out.tracks, tr)
 			}
 		}
+		// 3. Пространственные треки
+		if len(record.Decor) == 0 {
+			continue
+		}
+		for _, cTrack := range trek.CreateTracks3(chambers, tracks) {
+			dTrack := closestDecorTrack(record.Decor, cTrack.Line)
+			cos := cTrack.Cosines()
+			fmt.Fprintf(&out.tracks3, "%8d\t%8d\t%8d\t", record.Ctudc.Nevent(), cTrack.Chambers[0]+1, cTrack.Chambers[1]+1)
+			fmt.Fprintf(&out.tracks3, "%8f\t%8f\t%8f\t", cos.X, cos.Y, cos.Z)
+			fmt.Fprintf(&out.tracks3, "%8f\t%8f\t", toAng(cTrack.Zenith()), toAng(cTrack.Azimuth()))
+			fmt.Fprintf(&out.tracks3, "%8f\t%8f\t", toAng(trek.Zenith(dTrack.Vector)), toAng(trek.Azimuth(dTrack.Vector)))
+			fmt.Fprintf(&out.tracks3, "%8f\t%8f\n", toAng(trek.SpaceAngle(cTrack.Line.Vector, dTrack.Vector)), linesDistance(cTrack.Line, dTrack))
+		}
 	}
-	return nil
+	return out, nil
+}
+
+// openExtData открывает файл extctudc рана run и читает его заголовок.
+func openExtData(run int) (*dataReader, *extReader, error) {
+	filename, err := findData(formatExtFilename(run))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed open extctudc.tds: %s", err)
+	}
+	f, err := openData(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed open extctudc.tds: %s", err)
+	}
+	r := bufio.NewReader(f)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("Failed read header of extctudc.tds: %s", err)
+	}
+	er, err := newExtReader(r, header)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, er, nil
 }
 
 func handle(runs []int) error {
-	h, err := NewHandler()
+	h, err := NewHandler(*trackFormat)
 	if err != nil {
 		return err
 	}
 	defer h.Close()
-	return h.Handle(runs)
+	return h.Handle(runs, *jobs)
+}
+
+// closestTrack возвращает трек из tracks, ближайший к проекции трека ДЕКОР dTrack.
+// Треки группы мюонов почти параллельны, поэтому сравнение ведется по смещению b.
+func closestTrack(tracks []trek.TrackDesc, dTrack geo.Line2) *trek.TrackDesc {
+	best := &tracks[0]
+	for i := range tracks {
+		if math.Abs(tracks[i].Line.B()-dTrack.B()) < math.Abs(best.Line.B()-dTrack.B()) {
+			best = &tracks[i]
+		}
+	}
+	return best
+}
+
+// closestDecorTrack возвращает трек ДЕКОР, ближайший по направлению к прямой line.
+func closestDecorTrack(tracks []trek.DecorTrack, line geo.Line3) geo.Line3 {
+	best := tracks[0].Track
+	for _, t := range tracks[1:] {
+		if trek.SpaceAngle(t.Track.Vector, line.Vector) < trek.SpaceAngle(best.Vector, line.Vector) {
+			best = t.Track
+		}
+	}
+	return best
+}
+
+// linesDistance возвращает расстояние между прямыми l1 и l2.
+func linesDistance(l1, l2 geo.Line3) float64 {
+	d := l2.Point.Sub(l1.Point)
+	n := l1.Vector.Cross(l2.Vector)
+	if n.Len() == 0 {
+		return d.Cross(l1.Vector.Ort()).Len()
+	}
+	return math.Abs(d.Dot(n.Ort()))
 }
 
 func toAng(rad float64) float64 {
 	return rad / math.Pi * 180
 }
 
+// nevodCoord система координат НЕВОД относительно системы координат chambers.conf.new.
+var nevodCoord = geo.NewCoordSystem(
+	geo.Vec3{X: 26891.4, Y: -10028.6, Z: -9572.1},
+	geo.Vec3{X: 0, Y: 1, Z: 0},
+	geo.Vec3{X: -1, Y: 0, Z: 0},
+	geo.Vec3{X: 0, Y: 0, Z: 1})
+
 func convertConfig(config []trek.ChamberDesc) {
-	coor := geo.NewCoordSystem(
-		geo.Vec3{X: 26891.4, Y: -10028.6, Z: -9572.1},
-		geo.Vec3{X: 0, Y: 1, Z: 0},
-		geo.Vec3{X: -1, Y: 0, Z: 0},
-		geo.Vec3{X: 0, Y: 0, Z: 1})
 	for i := range config {
 		for p := range config[i].Points {
 			config[i].Points[p].Y = -config[i].Points[p].Y
-			config[i].Points[p] = coor.ConvertVector(config[i].Points[p])
+			config[i].Points[p] = nevodCoord.ConvertVector(config[i].Points[p])
 		}
 		config[i].Number--
 	}
 }
 
-func readChamberConfig(filename string) ([]trek.ChamberDesc, error) {
+// restoreConfig выполняет преобразование, обратное convertConfig.
+func restoreConfig(config []trek.ChamberDesc) {
+	for i := range config {
+		for p := range config[i].Points {
+			config[i].Points[p] = nevodCoord.RestoreVector(config[i].Points[p])
+			config[i].Points[p].Y = -config[i].Points[p].Y
+		}
+		config[i].Number++
+	}
+}
+
+// readRawChamberConfig считывает описания камер из filename без перевода в систему координат НЕВОД.
+func readRawChamberConfig(filename string) ([]trek.ChamberDesc, error) {
 	var chamConfig []trek.ChamberDesc
 	if data, err := ioutil.ReadFile(filename); err != nil {
 		return nil, err
 	} else if err := json.Unmarshal(data, &chamConfig); err != nil {
 		return nil, err
 	}
+	return chamConfig, nil
+}
+
+// readRunsChamberConfig читает функцией read chambers.conf.new каждого из ранов runs
+// и возвращает общую конфигурацию, раны с конфигурацией и раны, конфигурацию которых прочитать не удалось.
+// Результаты по нескольким ранам относятся к одной геометрии камер,
+// поэтому различие конфигураций ранов - ошибка.
+func readRunsChamberConfig(runs []int, read func(string) ([]trek.ChamberDesc, error)) ([]trek.ChamberDesc, []int, []int, error) {
+	var (
+		config     []trek.ChamberDesc
+		first      int
+		ok, failed []int
+	)
+	for _, run := range runs {
+		c, err := read(formatChamberConfig(run))
+		if err != nil {
+			log.Printf("Run %d: failed read chamber config: %s\n", run, err)
+			failed = append(failed, run)
+			continue
+		}
+		if config == nil {
+			config, first = c, run
+		} else if !reflect.DeepEqual(c, config) {
+			return nil, nil, nil, fmt.Errorf("chamber config of run %d differs from run %d", run, first)
+		}
+		ok = append(ok, run)
+	}
+	if config == nil {
+		return nil, nil, failed, fmt.Errorf("no chamber config")
+	}
+	return config, ok, failed, nil
+}
+
+// writeRawChamberConfig записывает описания камер в filename в формате chambers.conf.new.
+func writeRawChamberConfig(filename string, chamConfig []trek.ChamberDesc) error {
+	data, err := json.MarshalIndent(chamConfig, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0666)
+}
+
+func readChamberConfig(filename string) ([]trek.ChamberDesc, error) {
+	chamConfig, err := readRawChamberConfig(filename)
+	if err != nil {
+		return nil, err
+	}
 	convertConfig(chamConfig)
 	return chamConfig, nil
 }
 
-func readChambers(filename string) (map[int]*trek.Chamber, error) {
+// readCalibration считывает таблицы r(t) из filename.
+// Если файл отсутствует, возвращает пустую калибровку.
+func readCalibration(filename string) (trek.Calibration, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return trek.Calibration{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return trek.ReadCalibration(f)
+}
+
+// applyCalibration подставляет таблицы r(t) из calib в описания камер.
+// Ключи calib соответствуют номерам камер в chambers.conf.new (нумерация с 1).
+func applyCalibration(config []trek.ChamberDesc, calib trek.Calibration) {
+	for i := range config {
+		if rt, ok := calib[config[i].Number+1]; ok {
+			config[i].RT = rt
+		}
+	}
+}
+
+func readChambers(filename, rtFilename string) (map[int]*trek.Chamber, error) {
 	chamConfig, err := readChamberConfig(filename)
 	if err != nil {
 		return nil, err
 	}
+	calib, err := readCalibration(rtFilename)
+	if err != nil {
+		return nil, err
+	}
+	applyCalibration(chamConfig, calib)
 	chambers := make(map[int]*trek.Chamber)
 	for i := range chamConfig {
 		chambers[chamConfig[i].Number] = trek.NewChamber(chamConfig[i])
 	}
 	return chambers, nil
 }
+
+// readRunChambers читает камеры рана run с таблицами r(t) из файла флага -rt,
+// а если он не задан - из необязательного файла chambers.rt в директории рана.
+func readRunChambers(run int) (map[int]*trek.Chamber, error) {
+	rtFilename := path.Join(formatRunDir(run), "chambers.rt")
+	if *rtFile != "" {
+		// Отсутствие явно заданного файла - ошибка, а не работа без калибровки.
+		if _, err := os.Stat(*rtFile); err != nil {
+			return nil, fmt.Errorf("Failed read calibration: %s", err)
+		}
+		rtFilename = *rtFile
+	}
+	return readChambers(formatChamberConfig(run), rtFilename)
+}
@@ -0,0 +1,80 @@
+package main
+
+// histogram содержит одномерную гистограмму с равными бинами.
+type histogram struct {
+	min   float64
+	width float64
+	bins  []float64
+}
+
+func newHistogram(min, max float64, n int) *histogram {
+	return &histogram{
+		min:   min,
+		width: (max - min) / float64(n),
+		bins:  make([]float64, n),
+	}
+}
+
+// bin возвращает номер бина для значения x, либо -1, если x вне гистограммы.
+func (h *histogram) bin(x float64) int {
+	if x < h.min {
+		return -1
+	}
+	i := int((x - h.min) / h.width)
+	if i >= len(h.bins) {
+		return -1
+	}
+	return i
+}
+
+func (h *histogram) fill(x float64) {
+	if i := h.bin(x); i != -1 {
+		h.bins[i]++
+	}
+}
+
+func (h *histogram) center(i int) float64 {
+	return h.min + (float64(i)+0.5)*h.width
+}
+
+func (h *histogram) entries() float64 {
+	var sum float64
+	for _, n := range h.bins {
+		sum += n
+	}
+	return sum
+}
+
+// histogram2 содержит двумерную гистограмму с равными бинами.
+type histogram2 struct {
+	x, y *histogram
+	bins [][]float64
+}
+
+func newHistogram2(xmin, xmax float64, nx int, ymin, ymax float64, ny int) *histogram2 {
+	bins := make([][]float64, nx)
+	for i := range bins {
+		bins[i] = make([]float64, ny)
+	}
+	return &histogram2{
+		x:    newHistogram(xmin, xmax, nx),
+		y:    newHistogram(ymin, ymax, ny),
+		bins: bins,
+	}
+}
+
+func (h *histogram2) fill(x, y float64) {
+	i, j := h.x.bin(x), h.y.bin(y)
+	if i != -1 && j != -1 {
+		h.bins[i][j]++
+	}
+}
+
+// add прибавляет к h гистограмму other с теми же бинами.
+func (h *histogram2) add(other *histogram2) {
+	for i := range h.bins {
+		for j := range h.bins[i] {
+			h.bins[i][j] += other.bins[i][j]
+		}
+	}
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
 
 	"github.com/frostoov/CtudcHandler/trek"
 )
@@ -55,28 +55,29 @@ func ihepHandle(runs []int) error {
 		return err
 	}
 	defer h.Close()
-	if err := h.Handle(runs); err != nil {
-		return nil
-	}
+	// Статистика записывается и при ошибках отдельных ранов.
+	handleErr := h.Handle(runs)
 	for cham, stats := range h.stats {
-		filename := path.Join("ihep_output/statistics", fmt.Sprintf("chamber_%02d.txt", cham))
+		filename := ihepOutputPath("statistics", fmt.Sprintf("chamber_%02d.txt", cham))
 		if err := stats.Print(filename); err != nil {
 			return err
 		}
 	}
-	return nil
+	return handleErr
+}
+
+// ihepOutputPath возвращает путь elem в поддиректории ihep директории результатов.
+// Директория -out общая для команд, поэтому ihep очищает только свою поддиректорию.
+func ihepOutputPath(elem ...string) string {
+	return outputPath(append([]string{"ihep"}, elem...)...)
 }
 
 func NewIhepHandler() (*IhepHandler, error) {
-	os.RemoveAll("ihep_output")
-	if err := os.MkdirAll("ihep_output/tracks", 0777); err != nil {
-		return nil, fmt.Errorf("Failed create output dir: %s", err)
-	}
-	if err := os.MkdirAll("ihep_output/listing", 0777); err != nil {
-		return nil, fmt.Errorf("Failed create output dir: %s", err)
-	}
-	if err := os.MkdirAll("ihep_output/statistics", 0777); err != nil {
-		return nil, fmt.Errorf("Failed create output dir: %s", err)
+	os.RemoveAll(ihepOutputPath())
+	for _, subdir := range []string{"tracks", "listing", "statistics"} {
+		if err := os.MkdirAll(ihepOutputPath(subdir), 0777); err != nil {
+			return nil, fmt.Errorf("Failed create output dir: %s", err)
+		}
 	}
 	return &IhepHandler{
 		tracksFiles: make(map[int]*os.File),
@@ -95,16 +96,18 @@ func (h *IhepHandler) Close() {
 }
 
 func (h *IhepHandler) Handle(runs []int) error {
+	var failed []int
 	for _, run := range runs {
-		root := formatRunDir(run)
+		root := formatCtudcSubdir(run)
 		log.Println("Processing ", root)
 		if err := h.handleRun(root); err != nil {
 			log.Println("Failed:", err)
+			failed = append(failed, run)
 		} else {
 			log.Println("Success")
 		}
 	}
-	return nil
+	return failedRunsError(failed, len(runs))
 }
 
 func fullDepth(ds *[4]int) bool {
@@ -126,17 +129,18 @@ func singleDepth(ds *[4]int) bool {
 }
 
 func (h *IhepHandler) handleRun(root string) error {
-	reader, err := ctudcReader(root)
+	reader, err := ctudcReader(context.Background(), root)
 	if err != nil {
 		return err
 	}
-	for r := range reader {
+	defer reader.Close()
+	for r := range reader.C {
 		ds := r.ChamberDepths()
 		for cham, times := range r.Times() {
 			// Listing
 			w := h.listFiles[cham]
 			if w == nil {
-				filename := path.Join("ihep_output/listing", fmt.Sprintf("chamber_%02d.txt", cham))
+				filename := ihepOutputPath("listing", fmt.Sprintf("chamber_%02d.txt", cham))
 				if f, err := os.Create(filename); err != nil {
 					return err
 				} else {
@@ -162,12 +166,13 @@ func (h *IhepHandler) handleRun(root string) error {
 				stats.sevents++
 
 				t1, t2, t3, t4 := times[0][0], times[1][0], times[2][0], times[3][0]
-				k1 := t1 - t2 - t3 + t4
-				k2 := t1 - 3*t2 + 3*t3 - t4
+				// Времена беззнаковые, поэтому комбинации считаются в знаковых целых.
+				k1 := int(t1) - int(t2) - int(t3) + int(t4)
+				k2 := int(t1) - 3*int(t2) + 3*int(t3) - int(t4)
 
 				w := h.tracksFiles[cham]
 				if w == nil {
-					filename := path.Join("ihep_output/tracks", fmt.Sprintf("chamber_%02d.txt", cham))
+					filename := ihepOutputPath("tracks", fmt.Sprintf("chamber_%02d.txt", cham))
 					if f, err := os.Create(filename); err != nil {
 						return err
 					} else {
@@ -176,12 +181,12 @@ func (h *IhepHandler) handleRun(root string) error {
 					}
 					fmt.Fprintln(w, "WIRE_1\tWIRE_2\tWIRE_3\tWIRE_4\tk1\tk2")
 				}
-				fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%f\t%f\n", t1, t2, t3, t4, k1, k2)
+				fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\n", t1, t2, t3, t4, k1, k2)
 
 			}
 		}
 	}
-	return nil
+	return reader.Err()
 }
 
 func printIhepEventListing(w io.Writer, times *trek.ChamTimes) {
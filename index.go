@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	path "path/filepath"
+	"strings"
+
+	"github.com/frostoov/CtudcHandler/nevod"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// Заголовок файла индекса .tdsidx.
+const indexMagic = "TDSidx1\n"
+
+// indexFile содержит индексированный файл и его размер на момент индексации.
+type indexFile struct {
+	name string
+	size int64
+}
+
+// indexEntry содержит положение события в индексированных файлах.
+type indexEntry struct {
+	Nevent uint32
+	File   uint32
+	Offset int64
+}
+
+// tdsIndex отображает номера событий в файлы и смещения в них.
+type tdsIndex struct {
+	files   []indexFile
+	entries []indexEntry
+	byEvent map[uint32][]int
+}
+
+func formatExtIndex(run int) string {
+	return path.Join(formatRunDir(run), fmt.Sprintf("extctudc_%05d.tdsidx", run))
+}
+
+func formatCtudcIndex(run int) string {
+	return path.Join(formatCtudcSubdir(run), fmt.Sprintf("ctudc_%05d.tdsidx", run))
+}
+
+func (idx *tdsIndex) add(nevent uint, file int, offset int64) {
+	idx.entries = append(idx.entries, indexEntry{Nevent: uint32(nevent), File: uint32(file), Offset: offset})
+}
+
+// find возвращает положения событий с номером nevent в порядке следования в файлах.
+func (idx *tdsIndex) find(nevent uint) []indexEntry {
+	if idx.byEvent == nil {
+		idx.byEvent = make(map[uint32][]int, len(idx.entries))
+		for i := range idx.entries {
+			n := idx.entries[i].Nevent
+			idx.byEvent[n] = append(idx.byEvent[n], i)
+		}
+	}
+	var entries []indexEntry
+	for _, i := range idx.byEvent[uint32(nevent)] {
+		entries = append(entries, idx.entries[i])
+	}
+	return entries
+}
+
+// valid проверяет, что индекс построен по файлам paths в их текущем состоянии.
+func (idx *tdsIndex) valid(paths []string) bool {
+	if len(idx.files) != len(paths) {
+		return false
+	}
+	for i, filename := range paths {
+		stat, err := os.Stat(filename)
+		if err != nil || idx.files[i].name != path.Base(filename) || idx.files[i].size != stat.Size() {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *tdsIndex) write(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString(indexMagic)
+	binary.Write(w, binary.LittleEndian, uint32(len(idx.files)))
+	for _, file := range idx.files {
+		binary.Write(w, binary.LittleEndian, uint32(len(file.name)))
+		w.WriteString(file.name)
+		binary.Write(w, binary.LittleEndian, file.size)
+	}
+	binary.Write(w, binary.LittleEndian, uint64(len(idx.entries)))
+	if err := binary.Write(w, binary.LittleEndian, idx.entries); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func readIndex(filename string) (*tdsIndex, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, err := r.ReadString('\n'); err != nil || magic != indexMagic {
+		return nil, fmt.Errorf("invalid index header %q", magic)
+	}
+	idx := new(tdsIndex)
+	var nfiles uint32
+	if err := binary.Read(r, binary.LittleEndian, &nfiles); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < nfiles; i++ {
+		var l uint32
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return nil, err
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		file := indexFile{name: string(name)}
+		if err := binary.Read(r, binary.LittleEndian, &file.size); err != nil {
+			return nil, err
+		}
+		idx.files = append(idx.files, file)
+	}
+	var nentries uint64
+	if err := binary.Read(r, binary.LittleEndian, &nentries); err != nil {
+		return nil, err
+	}
+	idx.entries = make([]indexEntry, nentries)
+	if err := binary.Read(r, binary.LittleEndian, idx.entries); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func newIndex(paths []string) (*tdsIndex, error) {
+	idx := new(tdsIndex)
+	for _, filename := range paths {
+		stat, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		idx.files = append(idx.files, indexFile{name: path.Base(filename), size: stat.Size()})
+	}
+	return idx, nil
+}
+
+// buildCtudcIndex строит индекс событий файлов КТУДК paths.
+func buildCtudcIndex(paths []string) (*tdsIndex, error) {
+	idx, err := newIndex(paths)
+	if err != nil {
+		return nil, err
+	}
+	for i, filename := range paths {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		s, err := trek.NewScanner(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		s.SetName(path.Base(filename))
+		for s.Scan() {
+			idx.add(s.Record().Nevent(), i, s.Offset())
+		}
+		f.Close()
+		if s.Err() != nil {
+			log.Println("Index is incomplete:", s.Err())
+		}
+	}
+	return idx, nil
+}
+
+// buildExtIndex строит индекс событий объединенного файла filename.
+// Смещения отсчитываются в распакованных данных.
+func buildExtIndex(filename string) (*tdsIndex, error) {
+	idx, err := newIndex([]string{filename})
+	if err != nil {
+		return nil, err
+	}
+	f, err := openData(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	counter := trek.NewCountingReader(f)
+	r := bufio.NewReader(counter)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("Failed read header: %s", err)
+	}
+	er, err := newExtReader(r, header)
+	if err != nil {
+		return nil, err
+	}
+	var record trek.ExtEvent
+	for {
+		offset := counter.Count() - int64(r.Buffered())
+		if err := er.Read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Index of %s is incomplete: %s\n", filename, err)
+			break
+		}
+		idx.add(record.Ctudc.Nevent(), 0, offset)
+	}
+	return idx, nil
+}
+
+// loadIndex читает индекс из файла idxname, если он соответствует файлам paths,
+// иначе строит его функцией build и сохраняет.
+func loadIndex(idxname string, paths []string, rebuild bool, build func() (*tdsIndex, error)) (*tdsIndex, error) {
+	if !rebuild {
+		if idx, err := readIndex(idxname); err == nil && idx.valid(paths) {
+			return idx, nil
+		}
+	}
+	log.Println("Building index: ", idxname)
+	idx, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.write(idxname); err != nil {
+		log.Printf("Failed write index %s: %s\n", idxname, err)
+	}
+	return idx, nil
+}
+
+// runReader обеспечивает произвольный доступ к событиям рана.
+// Если ран объединен, события читаются из файла extctudc, иначе из файлов КТУДК.
+type runReader struct {
+	ext   bool
+	full  bool
+	paths []string
+	idx   *tdsIndex
+
+	cur     int
+	f       *dataReader
+	scanner *trek.Scanner
+	extData *extReader
+}
+
+// openRunReader открывает ран run для произвольного доступа.
+// Индекс строится, если он отсутствует, устарел или rebuild.
+func openRunReader(run int, rebuild bool) (*runReader, error) {
+	r := new(runReader)
+	if extname, err := findData(formatExtFilename(run)); err == nil {
+		header, err := readHeaderLine(extname)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parseExtHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", extname, err)
+		}
+		r.ext, r.full, r.paths = true, file.Flags&trek.FlagFullNevod != 0, []string{extname}
+		r.idx, err = loadIndex(formatExtIndex(run), r.paths, rebuild, func() (*tdsIndex, error) {
+			return buildExtIndex(extname)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	set, err := readFileSet(formatCtudcSubdir(run), ".tds")
+	if err != nil {
+		return nil, err
+	}
+	r.paths = set.Paths()
+	r.idx, err = loadIndex(formatCtudcIndex(run), r.paths, rebuild, func() (*tdsIndex, error) {
+		return buildCtudcIndex(r.paths)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readHeaderLine возвращает первую строку файла filename вместе с переводом строки.
+func readHeaderLine(filename string) (string, error) {
+	f, err := openData(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Failed read header of %s: %s", filename, err)
+	}
+	return header, nil
+}
+
+// errEventNotFound возвращается Seek, если события нет в индексе.
+var errEventNotFound = errors.New("event not found")
+
+// Seek устанавливает позицию чтения на первое событие с номером nevent.
+// Смещения индекса отсчитываются в распакованном потоке, поэтому для сжатого файла
+// Seek распаковывает и отбрасывает все данные от начала файла до события:
+// для сжатых ранов индекс экономит только разбор событий, но не чтение файла.
+func (r *runReader) Seek(nevent uint) error {
+	entries := r.idx.find(nevent)
+	if len(entries) == 0 {
+		return errEventNotFound
+	}
+	return r.open(int(entries[0].File), entries[0].Offset)
+}
+
+func (r *runReader) open(file int, offset int64) error {
+	r.Close()
+	var header string
+	if !r.ext && offset != 0 {
+		// Сканер читает заголовок файла перед событиями.
+		var err error
+		if header, err = readHeaderLine(r.paths[file]); err != nil {
+			return err
+		}
+	}
+	f, err := openDataAt(r.paths[file], offset)
+	if err != nil {
+		return err
+	}
+	if r.ext {
+		r.extData = &extReader{r: bufio.NewReader(f), full: r.full}
+	} else {
+		var src io.Reader = f
+		if offset != 0 {
+			src = io.MultiReader(strings.NewReader(header), f)
+		}
+		s, err := trek.NewScanner(src)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		r.scanner = s
+	}
+	r.cur, r.f = file, f
+	return nil
+}
+
+// Read читает следующее событие рана в e. Для необъединенного рана заполняются только данные КТУДК.
+func (r *runReader) Read(e *trek.ExtEvent) error {
+	if r.f == nil {
+		return io.EOF
+	}
+	if r.ext {
+		return r.extData.Read(e)
+	}
+	for !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return err
+		}
+		if r.cur+1 >= len(r.paths) {
+			return io.EOF
+		}
+		if err := r.open(r.cur+1, 0); err != nil {
+			return err
+		}
+	}
+	e.Ctudc = r.scanner.Record().Copy()
+	e.Nevod = nevod.EventMeta{}
+	e.Decor, e.Full = nil, nil
+	return nil
+}
+
+func (r *runReader) Close() {
+	if r.f != nil {
+		r.f.Close()
+		r.f, r.scanner, r.extData = nil, nil, nil
+	}
+}
+
+// indexRuns перестраивает индексы событий ранов runs.
+func indexRuns(runs []int) error {
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Indexing run ", run)
+		r, err := openRunReader(run, true)
+		if err != nil {
+			return nil, err
+		}
+		r.Close()
+		return nil, nil
+	}, func(*runResult) error {
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// runResult содержит результат обработки одного рана.
+type runResult struct {
+	run    int
+	output interface{}
+	err    error
+}
+
+// processRuns выполняет process для каждого рана из runs, обрабатывая до jobs ранов одновременно.
+// Функция done вызывается последовательно для результатов в порядке runs.
+// Ран занимает слот, пока его результат не передан done, поэтому в памяти
+// одновременно находится не более jobs результатов.
+// Возвращает список ранов, обработка которых завершилась ошибкой.
+func processRuns(runs []int, jobs int, process func(run int) (interface{}, error), done func(r *runResult) error) []int {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]chan runResult, len(runs))
+	for i := range results {
+		results[i] = make(chan runResult, 1)
+	}
+	sem := make(chan struct{}, jobs)
+	go func() {
+		for i, run := range runs {
+			sem <- struct{}{}
+			go func(i, run int) {
+				output, err := process(run)
+				results[i] <- runResult{run: run, output: output, err: err}
+			}(i, run)
+		}
+	}()
+
+	var failed []int
+	for i := range results {
+		r := <-results[i]
+		if r.err == nil {
+			r.err = done(&r)
+		}
+		<-sem
+		if r.err != nil {
+			log.Printf("Run %d (%d/%d) failed: %s\n", r.run, i+1, len(runs), r.err)
+			failed = append(failed, r.run)
+		} else {
+			log.Printf("Run %d (%d/%d) success\n", r.run, i+1, len(runs))
+		}
+	}
+	return failed
+}
+
+// runsError содержит раны, обработка которых завершилась ошибкой.
+type runsError struct {
+	failed []int
+	total  int
+}
+
+func (e *runsError) Error() string {
+	return fmt.Sprintf("%d of %d runs failed: %v", len(e.failed), e.total, e.failed)
+}
+
+// failedRunsError возвращает ошибку со списком неудачных ранов failed из total или nil.
+func failedRunsError(failed []int, total int) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &runsError{failed: failed, total: total}
+}
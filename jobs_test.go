@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessRunsOrder(t *testing.T) {
+	runs := []int{5, 1, 4, 2, 3}
+	var order []int
+	failed := processRuns(runs, 3, func(run int) (interface{}, error) {
+		// Ранние раны обрабатываются дольше поздних.
+		time.Sleep(time.Duration(run) * time.Millisecond)
+		return run * 10, nil
+	}, func(r *runResult) error {
+		if r.output.(int) != r.run*10 {
+			t.Errorf("run %d: output %v", r.run, r.output)
+		}
+		order = append(order, r.run)
+		return nil
+	})
+	if len(failed) != 0 {
+		t.Errorf("failed runs %v", failed)
+	}
+	if !reflect.DeepEqual(order, runs) {
+		t.Errorf("done order %v, expected %v", order, runs)
+	}
+}
+
+func TestProcessRunsFailed(t *testing.T) {
+	runs := []int{1, 2, 3, 4, 5}
+	var done []int
+	failed := processRuns(runs, 2, func(run int) (interface{}, error) {
+		if run == 2 {
+			return nil, errors.New("process failed")
+		}
+		return nil, nil
+	}, func(r *runResult) error {
+		done = append(done, r.run)
+		if r.run == 4 {
+			return errors.New("done failed")
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(failed, []int{2, 4}) {
+		t.Errorf("failed runs %v, expected [2 4]", failed)
+	}
+	if !reflect.DeepEqual(done, []int{1, 3, 4, 5}) {
+		t.Errorf("done called for %v, expected [1 3 4 5]", done)
+	}
+	err := failedRunsError(failed, len(runs))
+	var runsErr *runsError
+	if !errors.As(err, &runsErr) || runsErr.total != 5 || len(runsErr.failed) != 2 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if failedRunsError(nil, len(runs)) != nil {
+		t.Error("error without failed runs")
+	}
+}
+
+func TestProcessRunsBound(t *testing.T) {
+	const jobs = 3
+	var (
+		mu      sync.Mutex
+		held    int
+		maxHeld int
+	)
+	runs := make([]int, 30)
+	for i := range runs {
+		runs[i] = i + 1
+	}
+	processRuns(runs, jobs, func(run int) (interface{}, error) {
+		mu.Lock()
+		held++
+		if held > maxHeld {
+			maxHeld = held
+		}
+		mu.Unlock()
+		// Первый ран задерживает передачу результатов остальных.
+		if run == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil, nil
+	}, func(r *runResult) error {
+		mu.Lock()
+		held--
+		mu.Unlock()
+		return nil
+	})
+	if maxHeld > jobs {
+		t.Errorf("%d results held at once, expected at most %d", maxHeld, jobs)
+	}
+}
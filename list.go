@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -12,16 +13,19 @@ import (
 )
 
 func list(runList []int) error {
-	outdir := "./ctudc_listing"
+	outdir := *outDir
 	if err := os.MkdirAll(outdir, 0777); err != nil {
 		return err
 	}
+	var failed []int
 	for _, run := range runList {
-		root := formatRunDir(run)
 		log.Printf("Processing run #%v\n", run)
-		listRun(path.Join(root, "ctudc"), outdir)
+		if err := listRun(formatCtudcSubdir(run), outdir); err != nil {
+			log.Printf("Failed list run #%v: %s\n", run, err)
+			failed = append(failed, run)
+		}
 	}
-	return nil
+	return failedRunsError(failed, len(runList))
 }
 
 func listRun(dirname, outdir string) error {
@@ -35,12 +39,13 @@ func listRun(dirname, outdir string) error {
 			f.Close()
 		}
 	}()
-	r, err := ctudcReader(dirname)
+	r, err := ctudcReader(context.Background(), dirname)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 
-	for event := range r {
+	for event := range r.C {
 		for cham, times := range event.Times() {
 			filename := path.Join(outdir, fmt.Sprintf("chamber_%02d.txt", cham+1))
 			w := writers[filename]
@@ -57,7 +62,7 @@ func listRun(dirname, outdir string) error {
 			printEventListing(w, times)
 		}
 	}
-	return nil
+	return r.Err()
 }
 
 func printEventListing(w io.Writer, times *trek.ChamTimes) {
@@ -1,62 +1,19 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"path"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-type appConfig struct {
-	CtudcRoot string  `json:"ctudc_root"`
-	NevodRoot string  `json:"nevod_root"`
-	Speed     float64 `json:"speed"`
-	Offset    uint    `json:"offset"`
-}
-
-var appConf = readAppConfig()
-
-func readAppConfig() appConfig {
-	var conf appConfig
-	confpath := path.Join(os.Getenv("HOME"), ".config", "ctudc", "CtudcHandler.conf")
-	if runtime.GOOS == "windows" {
-		confpath = "CtudcHandler.conf"
-	}
-	data, err := ioutil.ReadFile(confpath)
-	if err != nil {
-		log.Fatalln("Failed read CtudcHandler.conf:", err)
-	}
-	if err := json.Unmarshal(data, &conf); err != nil {
-		log.Fatalln("Failed unmarshal CtudcHandler.conf:", err)
-	}
-	return conf
-}
-
-func formatRunDir(run int) string {
-	return path.Join(appConf.CtudcRoot, fmt.Sprintf("run_%05d", run))
-}
-
-func formatCtudcFilename(run, fileno int) string {
-	ctudcSubdir := formatCtudcSubdir(run)
-	return path.Join(ctudcSubdir, fmt.Sprintf("ctudc_%05d_%08d.tds", run, fileno))
-}
-
-func formatCtudcSubdir(run int) string {
-	return path.Join(formatRunDir(run), fmt.Sprintf("ctudc_%05d", run))
-}
-
-func formatNevodRunDir(run int) string {
-	return path.Join(appConf.NevodRoot, fmt.Sprintf("NAD_%03d", run))
-}
-
 func parseRuns(runList string) ([]int, error) {
 	if len(runList) == 0 {
 		return nil, nil
@@ -101,46 +58,358 @@ func parseRuns(runList string) ([]int, error) {
 	return runs, nil
 }
 
-var cmd = flag.String("cmd", "handle", "type of command: handle|merge|split|dcrsplit|dcrsplit-shsh")
-var runs = flag.String("runs", "", `list of runs, e.g. "1, 2, 3, 4, 6-10"`)
+// Флаги команд. Каждая команда регистрирует в своем наборе флагов только нужные ей.
+var (
+	configPath  = new(string)
+	outDir      = new(string)
+	runs        = new(string)
+	jobs        = new(int)
+	trackFormat = new(string)
+	decorSource = new(string)
+	fullNevod   = new(bool)
+	matchWindow = new(time.Duration)
+	matchOffset = new(time.Duration)
+	compression = new(string)
+	eventNumber = new(uint)
+	rtFile      = new(string)
+)
 
-func main() {
-	flag.Parse()
-	runList, err := parseRuns(*runs)
-	if err != nil {
-		log.Fatalln("Failed parse runs list:", err)
+// envDefault возвращает значение переменной окружения env или value, если она не задана.
+func envDefault(env, value string) string {
+	if v, ok := os.LookupEnv(env); ok {
+		return v
 	}
+	return value
+}
 
-	switch *cmd {
-	case "handle":
-		if err := handle(runList); err != nil {
-			log.Println("Failed handle data:", err)
-		}
-	case "merge":
-		if err := merge(runList); err != nil {
-			log.Println("Failed merge data:", err)
+func addRunsFlag(fs *flag.FlagSet) {
+	fs.StringVar(runs, "runs", "", `list of runs, e.g. "1, 2, 3, 4, 6-10"`)
+}
+
+func addJobsFlag(fs *flag.FlagSet) {
+	fs.IntVar(jobs, "jobs", 1, "number of runs processed concurrently")
+}
+
+// addOutFlag регистрирует флаг -out с директорией результатов dir по умолчанию.
+func addOutFlag(fs *flag.FlagSet, dir string) {
+	fs.StringVar(outDir, "out", envDefault(envOut, dir), "output directory (env "+envOut+")")
+}
+
+// addRTFlag регистрирует флаг -rt с файлом таблиц r(t), записанным командой calibrate.
+func addRTFlag(fs *flag.FlagSet) {
+	fs.StringVar(rtFile, "rt", "", "r(t) calibration file written by calibrate (default <run dir>/chambers.rt)")
+}
+
+func addCompressionFlag(fs *flag.FlagSet) {
+	fs.StringVar(compression, "compression", "none", "compression of written data: none|gzip|zstd")
+}
+
+// Коды завершения программы.
+const (
+	exitOK = 0
+	// Команда завершилась ошибкой
+	exitFailure = 1
+	// Неверные аргументы командной строки
+	exitUsage = 2
+	// Обработка части ранов завершилась ошибкой
+	exitRunsFailed = 3
+)
+
+const programName = "CtudcHandler"
+
+// command описывает команду программы.
+type command struct {
+	name string
+	// Описание позиционных аргументов; пусто, если команда обрабатывает раны -runs
+	args    string
+	summary string
+	// Действие для сообщения об ошибке
+	action string
+	flags  func(fs *flag.FlagSet)
+	run    func(runs []int, args []string) error
+}
+
+func runsFlags(out string, extra func(fs *flag.FlagSet)) func(fs *flag.FlagSet) {
+	return func(fs *flag.FlagSet) {
+		addRunsFlag(fs)
+		if out != "" {
+			addOutFlag(fs, out)
 		}
-	case "list":
-		if err := list(runList); err != nil {
-			log.Println("Failed list data:", err)
+		if extra != nil {
+			extra(fs)
 		}
-	case "ihep":
-		if err := ihepHandle(runList); err != nil {
-			log.Println("Failed list data:", err)
+	}
+}
+
+var commands = []*command{
+	{
+		name: "handle", summary: "reconstruct chamber tracks of runs", action: "handle data",
+		flags: runsFlags("output", func(fs *flag.FlagSet) {
+			addJobsFlag(fs)
+			addRTFlag(fs)
+			fs.StringVar(trackFormat, "format", "text", "format of tracks output: text|parquet")
+		}),
+		run: func(runs []int, _ []string) error { return handle(runs) },
+	},
+	{
+		name: "merge", summary: "merge CTUDC and NEVOD data of runs", action: "merge data",
+		flags: runsFlags("", func(fs *flag.FlagSet) {
+			addJobsFlag(fs)
+			addCompressionFlag(fs)
+			fs.StringVar(decorSource, "decor", "file", "source of decor tracks: file|nad")
+			fs.BoolVar(fullNevod, "full", false, "store full NEVOD events in merged data")
+			fs.DurationVar(matchWindow, "match-window", 0, "match CTUDC and NEVOD events by time within window instead of event number")
+			fs.DurationVar(matchOffset, "match-offset", 0, "offset added to NEVOD event time when matching by time")
+		}),
+		run: func(runs []int, _ []string) error { return merge(runs) },
+	},
+	{
+		name: "split", args: "file...", summary: "split raw CTUDC files into runs", action: "split data",
+		flags: addCompressionFlag,
+		run:   func(_ []int, args []string) error { return split(args) },
+	},
+	{
+		name: "dcrsplit", args: "file...", summary: "split DECOR tracks into decor.dat of runs", action: "split decor data",
+		run: func(_ []int, args []string) error { return dcrsplit(args, "decor.dat") },
+	},
+	{
+		name: "dcrsplit-shsh", args: "file...", summary: "split DECOR ShSh tracks into decor_shsh.dat of runs", action: "split decor data",
+		run: func(_ []int, args []string) error { return dcrsplit(args, "decor_shsh.dat") },
+	},
+	{
+		name: "calibrate", summary: "calibrate r(t) relations of chambers into <out>/chambers.rt, read by handle -rt", action: "calibrate data",
+		flags: runsFlags("output", nil),
+		run:   func(runs []int, _ []string) error { return calibrate(runs) },
+	},
+	{
+		name: "t0", summary: "extract T0 and Tmax of wires", action: "extract t0",
+		flags: runsFlags("output", nil),
+		run:   func(runs []int, _ []string) error { return t0(runs) },
+	},
+	{
+		name: "align", summary: "align chambers by DECOR tracks", action: "align chambers",
+		flags: runsFlags("output", nil),
+		run:   func(runs []int, _ []string) error { return align(runs) },
+	},
+	{
+		name: "efficiency", summary: "build efficiency maps of chambers and wires", action: "build efficiency maps",
+		flags: runsFlags("output", addRTFlag),
+		run:   func(runs []int, _ []string) error { return efficiency(runs) },
+	},
+	{
+		name: "export", args: "file...", summary: "export data files as NDJSON to stdout", action: "export data",
+		run: func(_ []int, args []string) error { return export(args) },
+	},
+	{
+		name: "nevod-monitor", summary: "dump NEVOD monitoring time series", action: "dump nevod monitoring",
+		flags: runsFlags("output", addJobsFlag),
+		run:   func(runs []int, _ []string) error { return nevodMonitor(runs) },
+	},
+	{
+		name: "index", summary: "build event indexes of runs (compressed runs are still decompressed from the start on seek)", action: "index data",
+		flags: runsFlags("", addJobsFlag),
+		run:   func(runs []int, _ []string) error { return indexRuns(runs) },
+	},
+	{
+		name: "event", summary: "print event -event of runs (compressed runs are decompressed up to the event)", action: "show event",
+		flags: runsFlags("", func(fs *flag.FlagSet) {
+			fs.UintVar(eventNumber, "event", 0, "number of printed event")
+		}),
+		run: func(runs []int, _ []string) error { return showEvent(runs, *eventNumber) },
+	},
+	{
+		name: "compress", summary: "compress raw and merged data of runs", action: "compress data",
+		flags: runsFlags("", func(fs *flag.FlagSet) {
+			addJobsFlag(fs)
+			fs.StringVar(compression, "compression", "gzip", "compression method: gzip|zstd")
+		}),
+		run: func(runs []int, _ []string) error { return compress(runs, *compression) },
+	},
+	{
+		name: "verify", summary: "verify integrity of raw and merged data", action: "verify data",
+		flags: runsFlags("", addJobsFlag),
+		run:   func(runs []int, _ []string) error { return verify(runs) },
+	},
+	{
+		name: "repair", summary: "repair damaged CTUDC files", action: "repair data",
+		flags: runsFlags("", addJobsFlag),
+		run:   func(runs []int, _ []string) error { return repair(runs) },
+	},
+	{
+		name: "list", summary: "list CTUDC hit times of chambers", action: "list data",
+		flags: runsFlags("ctudc_listing", nil),
+		run:   func(runs []int, _ []string) error { return list(runs) },
+	},
+	{
+		name: "ihep", summary: "write IHEP listings, tracks and statistics to <out>/ihep", action: "process IHEP data",
+		flags: runsFlags("output", nil),
+		run:   func(runs []int, _ []string) error { return ihepHandle(runs) },
+	},
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
 		}
-	case "split":
-		if err := split(flag.Args()); err != nil {
-			log.Println("Failed split data:", err)
+	}
+	return nil
+}
+
+// flagSet создает набор флагов команды c, выводящий справку в w.
+func (c *command) flagSet(w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(configPath, "config", os.Getenv(envConfig), "path to CtudcHandler.conf (env "+envConfig+")")
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	fs.Usage = func() {
+		args := c.args
+		if args == "" {
+			args = "-runs LIST"
 		}
-	case "dcrsplit":
-		if err := dcrsplit(flag.Args(), "decor.dat"); err != nil {
-			log.Println("Failed split decor data:", err)
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n", programName, c.name, args, capitalize(c.summary))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// usage выводит в w список команд.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [args]\n\nCommands:\n", programName)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s help <command>' for flags of the command.\n", programName)
+	fmt.Fprintf(w, "Exit status: %d on success, %d on failure, %d on usage error, %d if some runs failed.\n",
+		exitOK, exitFailure, exitUsage, exitRunsFailed)
+}
+
+// splitCommand выделяет из аргументов args имя команды и аргументы команды.
+// Поддерживается прежняя форма -cmd NAME; если первым указан другой флаг, выполняется handle.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	arg := strings.TrimPrefix(strings.TrimPrefix(args[0], "-"), "-")
+	switch {
+	case arg == "h" || arg == "help":
+		return "help", args[1:]
+	case arg == "cmd" && len(args) > 1:
+		return args[1], args[2:]
+	case strings.HasPrefix(arg, "cmd="):
+		return strings.TrimPrefix(arg, "cmd="), args[1:]
+	case strings.HasPrefix(args[0], "-"):
+		return "handle", args
+	}
+	return args[0], args[1:]
+}
+
+// help выводит справку по команде args[0] или список команд.
+func help(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return exitOK
+	}
+	c := findCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", programName, args[0])
+		usage(os.Stderr)
+		return exitUsage
+	}
+	c.flagSet(os.Stdout).Usage()
+	return exitOK
+}
+
+// execute выполняет команду name с аргументами args и возвращает код завершения.
+func execute(name string, args []string) int {
+	if name == "" {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	if name == "help" {
+		return help(args)
+	}
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", programName, name)
+		usage(os.Stderr)
+		return exitUsage
+	}
+	fs := c.flagSet(os.Stderr)
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	runList, err := parseRuns(*runs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: invalid runs list: %s\n", programName, name, err)
+		return exitUsage
+	}
+	if c.args == "" && len(runList) == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: -runs is required\n", programName, name)
+		fs.Usage()
+		return exitUsage
+	}
+	if c.args != "" && fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: %s expected\n", programName, name, c.args)
+		fs.Usage()
+		return exitUsage
+	}
+	conf, err := readAppConfig(*configPath)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	appConf = conf
+
+	err = c.run(runList, fs.Args())
+	var runsErr *runsError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &runsErr):
+		log.Printf("Failed %s: %d of %d runs failed\n", c.action, len(runsErr.failed), runsErr.total)
+		log.Printf("Failed runs: %s\n", formatRuns(runsErr.failed))
+		return exitRunsFailed
+	default:
+		log.Printf("Failed %s: %s\n", c.action, err)
+		return exitFailure
+	}
+}
+
+// formatRuns записывает список ранов в формате -runs, объединяя последовательные раны в диапазоны.
+func formatRuns(runs []int) string {
+	var parts []string
+	for i := 0; i < len(runs); {
+		j := i
+		for j+1 < len(runs) && runs[j+1] == runs[j]+1 {
+			j++
 		}
-	case "dcrsplit-shsh":
-		if err := dcrsplit(flag.Args(), "decor_shsh.dat"); err != nil {
-			log.Println("Failed split decor data:", err)
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", runs[i], runs[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(runs[i]))
 		}
-	default:
-		log.Println("Invalid cmd")
+		i = j + 1
 	}
+	return strings.Join(parts, ",")
+}
+
+func main() {
+	name, args := splitCommand(os.Args[1:])
+	os.Exit(execute(name, args))
 }
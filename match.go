@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Максимальное количество несопоставленных событий НЕВОД в буфере.
+	matchBufferSize = 10000
+	// Количество событий НЕВОД, следующих за событием КТУДК, после которого чтение вперед прекращается.
+	// Определяет допустимое нарушение порядка файлов.
+	matchLookahead = matchBufferSize / 2
+	// Разность времен, после которой событие НЕВОД с тем же номером считается записанным до сброса счетчика.
+	matchStaleAge = time.Minute
+	// Шаг по сопоставленным событиям между точками дрейфа времени в отчете.
+	matchDriftStep = 1000
+)
+
+// matchEntry содержит событие НЕВОД в буфере сопоставления.
+type matchEntry struct {
+	record *nevodRecord
+	used   bool
+}
+
+// driftPoint содержит разность времен событий КТУДК и НЕВОД.
+type driftPoint struct {
+	nevent uint
+	time   time.Time
+	dt     time.Duration
+}
+
+// matchReport содержит статистику сопоставления событий КТУДК и НЕВОД рана.
+type matchReport struct {
+	matched   int
+	ctudcOnly int
+	nevodOnly int
+	// События НЕВОД с номером рана, отличным от обрабатываемого
+	foreign int
+	drift   []driftPoint
+	// Суммы для среднего и линейного тренда дрейфа
+	n                        float64
+	sumT, sumD, sumTT, sumTD float64
+	start                    time.Time
+}
+
+func (r *matchReport) addDrift(nevent uint, t time.Time, dt time.Duration) {
+	if r.n == 0 {
+		r.start = t
+	}
+	if int(r.n)%matchDriftStep == 0 {
+		r.drift = append(r.drift, driftPoint{nevent: nevent, time: t, dt: dt})
+	}
+	x, y := t.Sub(r.start).Hours(), dt.Seconds()*1000
+	r.n++
+	r.sumT += x
+	r.sumD += y
+	r.sumTT += x * x
+	r.sumTD += x * y
+}
+
+// driftTrend возвращает средний дрейф в мс и его наклон в мс/ч.
+func (r *matchReport) driftTrend() (float64, float64) {
+	if r.n == 0 {
+		return 0, 0
+	}
+	mean := r.sumD / r.n
+	det := r.n*r.sumTT - r.sumT*r.sumT
+	if det == 0 {
+		return mean, 0
+	}
+	return mean, (r.n*r.sumTD - r.sumT*r.sumD) / det
+}
+
+// write записывает отчет в файл filename.
+func (r *matchReport) write(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	mean, slope := r.driftTrend()
+	fmt.Fprintf(w, "# %8s\t%10s\t%10s\t%10s\t%12s\t%12s\n", "matched", "ctudc_only", "nevod_only", "foreign", "drift[ms]", "slope[ms/h]")
+	fmt.Fprintf(w, "%10d\t%10d\t%10d\t%10d\t%12.3f\t%12.3f\n", r.matched, r.ctudcOnly, r.nevodOnly, r.foreign, mean, slope)
+	fmt.Fprintf(w, "# %8s\t%24s\t%12s\n", "event", "time", "drift[ms]")
+	for _, p := range r.drift {
+		fmt.Fprintf(w, "%10d\t%24s\t%12.3f\n", p.nevent, p.time.UTC().Format(monitorTimeLayout), p.dt.Seconds()*1000)
+	}
+	return w.Flush()
+}
+
+// eventMatcher сопоставляет события КТУДК с событиями потока НЕВОД.
+//
+// Несопоставленные события НЕВОД хранятся в буфере ограниченного размера,
+// поэтому допускаются пропуски событий в обоих потоках, сбросы счетчика событий
+// и нарушение порядка файлов в пределах буфера.
+// События сопоставляются по номеру или, если window != 0, по времени в пределах window.
+//
+// Поток НЕВОД читается вперед, пока в буфере не окажется matchLookahead событий,
+// следующих за текущим событием КТУДК. Из буфера удаляются только события,
+// предшествующие текущему событию КТУДК, поэтому пропуск одного события
+// не приводит к потере последующих.
+type eventMatcher struct {
+	stream *nevodStream
+	nrun   uint32
+	window time.Duration
+	offset time.Duration
+
+	queue    []*matchEntry
+	byEvent  map[uint32][]*matchEntry
+	byBucket map[int64][]*matchEntry
+	// Количество несопоставленных событий в буфере
+	pending int
+	eof     bool
+
+	report matchReport
+}
+
+// newEventMatcher создает сопоставитель событий рана nrun с потоком stream.
+// Ко времени событий НЕВОД прибавляется offset.
+func newEventMatcher(stream *nevodStream, nrun int, window, offset time.Duration) *eventMatcher {
+	return &eventMatcher{
+		stream:   stream,
+		nrun:     uint32(nrun),
+		window:   window,
+		offset:   offset,
+		byEvent:  make(map[uint32][]*matchEntry),
+		byBucket: make(map[int64][]*matchEntry),
+	}
+}
+
+func (m *eventMatcher) bucket(t time.Time) int64 {
+	return t.UnixNano() / int64(m.window)
+}
+
+func (m *eventMatcher) nevodTime(r *nevodRecord) time.Time {
+	return r.Time.Add(m.offset)
+}
+
+// read читает следующее событие НЕВОД в буфер и возвращает его или nil в конце потока.
+func (m *eventMatcher) read() *matchEntry {
+	for record := range m.stream.C {
+		if record.Meta.Nrun != m.nrun {
+			m.report.foreign++
+			continue
+		}
+		r := record
+		entry := &matchEntry{record: &r}
+		m.queue = append(m.queue, entry)
+		m.pending++
+		m.byEvent[r.Meta.Nevent] = append(m.byEvent[r.Meta.Nevent], entry)
+		if m.window != 0 {
+			b := m.bucket(m.nevodTime(&r))
+			m.byBucket[b] = append(m.byBucket[b], entry)
+		}
+		return entry
+	}
+	m.eof = true
+	return nil
+}
+
+// stale сообщает, что событие НЕВОД r записано задолго до события КТУДК e,
+// например до сброса счетчика событий. Если время одного из событий неизвестно, возвращает false.
+func stale(r *nevodRecord, e *trek.Event) bool {
+	return !e.Time().IsZero() && !r.Time.IsZero() && r.Time.Before(e.Time().Add(-matchStaleAge))
+}
+
+// older сообщает, что событие НЕВОД r предшествует событию КТУДК e.
+func (m *eventMatcher) older(r *nevodRecord, e *trek.Event) bool {
+	if m.window != 0 {
+		return m.nevodTime(r).Before(e.Time().Add(-m.window))
+	}
+	return uint(r.Meta.Nevent) < e.Nevent() || stale(r, e)
+}
+
+// ahead сообщает, что событие НЕВОД r следует за событием КТУДК e.
+func (m *eventMatcher) ahead(r *nevodRecord, e *trek.Event) bool {
+	if m.window != 0 {
+		return m.nevodTime(r).After(e.Time().Add(m.window))
+	}
+	return uint(r.Meta.Nevent) > e.Nevent() && !stale(r, e)
+}
+
+// countAhead возвращает количество событий буфера, следующих за событием КТУДК e.
+func (m *eventMatcher) countAhead(e *trek.Event) int {
+	n := 0
+	for _, entry := range m.queue {
+		if !entry.used && m.ahead(entry.record, e) {
+			n++
+		}
+	}
+	return n
+}
+
+// evictOlder удаляет из буфера события, предшествующие событию КТУДК e,
+// и учитывает их как события только НЕВОД. Возвращает количество удаленных событий.
+func (m *eventMatcher) evictOlder(e *trek.Event) int {
+	n := 0
+	queue := m.queue[:0]
+	for _, entry := range m.queue {
+		if !entry.used && m.older(entry.record, e) {
+			m.remove(entry)
+			n++
+		}
+		if !entry.used {
+			queue = append(queue, entry)
+		}
+	}
+	for i := len(queue); i < len(m.queue); i++ {
+		m.queue[i] = nil
+	}
+	m.queue = queue
+	m.report.nevodOnly += n
+	return n
+}
+
+// compact удаляет сопоставленные события из начала очереди.
+// Если сопоставленных событий в очереди слишком много, очередь перестраивается.
+func (m *eventMatcher) compact() {
+	for len(m.queue) != 0 && m.queue[0].used {
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+	}
+	if len(m.queue) > 2*matchBufferSize {
+		queue := make([]*matchEntry, 0, m.pending)
+		for _, entry := range m.queue {
+			if !entry.used {
+				queue = append(queue, entry)
+			}
+		}
+		m.queue = queue
+	}
+}
+func removeEntry(entries []*matchEntry, entry *matchEntry) []*matchEntry {
+	for i := range entries {
+		if entries[i] == entry {
+			return append(entries[:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
+
+func (m *eventMatcher) remove(entry *matchEntry) {
+	entry.used = true
+	m.pending--
+	nevent := entry.record.Meta.Nevent
+	if entries := removeEntry(m.byEvent[nevent], entry); len(entries) != 0 {
+		m.byEvent[nevent] = entries
+	} else {
+		delete(m.byEvent, nevent)
+	}
+	if m.window != 0 {
+		b := m.bucket(m.nevodTime(entry.record))
+		if entries := removeEntry(m.byBucket[b], entry); len(entries) != 0 {
+			m.byBucket[b] = entries
+		} else {
+			delete(m.byBucket, b)
+		}
+	}
+}
+
+// find ищет в буфере событие НЕВОД, соответствующее событию КТУДК e.
+func (m *eventMatcher) find(e *trek.Event) *matchEntry {
+	if m.window == 0 {
+		// После сброса счетчика в буфере могут остаться давние события с тем же номером.
+		for _, entry := range m.byEvent[uint32(e.Nevent())] {
+			if !stale(entry.record, e) {
+				return entry
+			}
+		}
+		return nil
+	}
+	var (
+		best   *matchEntry
+		bestDt = time.Duration(math.MaxInt64)
+	)
+	b := m.bucket(e.Time())
+	for _, entries := range [][]*matchEntry{m.byBucket[b-1], m.byBucket[b], m.byBucket[b+1]} {
+		for _, entry := range entries {
+			dt := e.Time().Sub(m.nevodTime(entry.record))
+			if dt < 0 {
+				dt = -dt
+			}
+			if dt <= m.window && dt < bestDt {
+				best, bestDt = entry, dt
+			}
+		}
+	}
+	return best
+}
+
+// match возвращает событие НЕВОД, соответствующее событию КТУДК e, или nil.
+func (m *eventMatcher) match(e *trek.Event) *nevodRecord {
+	entry := m.find(e)
+	if entry == nil && !m.eof {
+		ahead := m.countAhead(e)
+		for entry == nil && ahead < matchLookahead {
+			if m.pending >= matchBufferSize && m.evictOlder(e) == 0 {
+				// Буфер заполнен событиями, которые еще могут быть сопоставлены.
+				break
+			}
+			read := m.read()
+			if read == nil {
+				break
+			}
+			if m.ahead(read.record, e) {
+				ahead++
+			}
+			entry = m.find(e)
+		}
+	}
+	if entry == nil {
+		m.report.ctudcOnly++
+		return nil
+	}
+	m.remove(entry)
+	m.compact()
+	m.report.matched++
+	if !e.Time().IsZero() && !entry.record.Time.IsZero() {
+		m.report.addDrift(e.Nevent(), e.Time(), e.Time().Sub(entry.record.Time))
+	}
+	return entry.record
+}
+
+// finish дочитывает поток НЕВОД и учитывает оставшиеся события как события только НЕВОД.
+func (m *eventMatcher) finish() *matchReport {
+	for record := range m.stream.C {
+		if record.Meta.Nrun != m.nrun {
+			m.report.foreign++
+		} else {
+			m.report.nevodOnly++
+		}
+	}
+	m.eof = true
+	m.report.nevodOnly += m.pending
+	m.pending = 0
+	m.queue = nil
+	return &m.report
+}
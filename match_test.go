@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/frostoov/CtudcHandler/nevod"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const testRun = 7
+
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testTime(nevent int) time.Time {
+	return testStart.Add(time.Duration(nevent) * time.Second)
+}
+
+func testCtudcEvent(t *testing.T, nevent int) trek.Event {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint64{testRun, uint64(nevent)})
+	binary.Write(&buf, binary.LittleEndian, testTime(nevent).UnixNano()/int64(time.Millisecond))
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	var e trek.Event
+	if err := e.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func testNevodStream(nevents []int) *nevodStream {
+	c := make(chan nevodRecord, len(nevents))
+	for _, n := range nevents {
+		c <- nevodRecord{
+			Meta: nevod.EventMeta{Nrun: testRun, Nevent: uint32(n)},
+			Time: testTime(n),
+		}
+	}
+	close(c)
+	_, state := newStreamState(context.Background())
+	state.finish(nil)
+	return &nevodStream{C: c, streamState: state}
+}
+
+// seq возвращает номера событий [first, last] без номеров skip.
+func seq(first, last int, skip ...int) []int {
+	skipped := make(map[int]bool)
+	for _, n := range skip {
+		skipped[n] = true
+	}
+	var s []int
+	for n := first; n <= last; n++ {
+		if !skipped[n] {
+			s = append(s, n)
+		}
+	}
+	return s
+}
+
+func runMatcher(t *testing.T, ctudc, nevod []int, window time.Duration) *matchReport {
+	m := newEventMatcher(testNevodStream(nevod), testRun, window, 0)
+	for _, n := range ctudc {
+		e := testCtudcEvent(t, n)
+		if r := m.match(&e); r != nil {
+			if window == 0 && uint(r.Meta.Nevent) != e.Nevent() {
+				t.Fatalf("event %d matched with nevod event %d", e.Nevent(), r.Meta.Nevent)
+			}
+		}
+	}
+	return m.finish()
+}
+
+func checkReport(t *testing.T, r *matchReport, matched, ctudcOnly, nevodOnly int) {
+	t.Helper()
+	if r.matched != matched || r.ctudcOnly != ctudcOnly || r.nevodOnly != nevodOnly {
+		t.Errorf("matched %d, ctudc only %d, nevod only %d; expected %d, %d, %d",
+			r.matched, r.ctudcOnly, r.nevodOnly, matched, ctudcOnly, nevodOnly)
+	}
+}
+
+func TestMatcherMissingNevod(t *testing.T) {
+	for _, window := range []time.Duration{0, 100 * time.Millisecond} {
+		r := runMatcher(t, seq(1, 20000), seq(1, 20000, 5), window)
+		checkReport(t, r, 19999, 1, 0)
+	}
+}
+
+func TestMatcherMissingCtudc(t *testing.T) {
+	for _, window := range []time.Duration{0, 100 * time.Millisecond} {
+		r := runMatcher(t, seq(1, 20000, 5, 12000), seq(1, 20000), window)
+		checkReport(t, r, 19998, 0, 2)
+	}
+}
+
+func TestMatcherOutOfOrderFiles(t *testing.T) {
+	// Файлы НЕВОД прочитаны в порядке 2, 1, 3.
+	nevod := append(seq(1001, 2000), seq(1, 1000)...)
+	nevod = append(nevod, seq(2001, 3000)...)
+	r := runMatcher(t, seq(1, 3000), nevod, 0)
+	checkReport(t, r, 3000, 0, 0)
+}
+
+func TestMatcherForeign(t *testing.T) {
+	m := newEventMatcher(testNevodStream(seq(1, 3)), testRun+1, 0, 0)
+	e := testCtudcEvent(t, 1)
+	if m.match(&e) != nil {
+		t.Error("matched event of foreign run")
+	}
+	if r := m.finish(); r.foreign != 3 || r.ctudcOnly != 1 {
+		t.Errorf("foreign %d, ctudc only %d", r.foreign, r.ctudcOnly)
+	}
+}
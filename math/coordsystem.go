@@ -27,6 +27,23 @@ func (c *CoordSystem) ConvertLine(l Line3) Line3 {
 	}
 }
 
+// RestoreVector переводит вектор v из системы координат c в исходную систему координат.
+func (c *CoordSystem) RestoreVector(v Vec3) Vec3 {
+	return c.restoreRotation(v).Add(c.offset)
+}
+
+// RestoreLine переводит прямую l из системы координат c в исходную систему координат.
+func (c *CoordSystem) RestoreLine(l Line3) Line3 {
+	return Line3{
+		Vector: c.restoreRotation(l.Vector),
+		Point:  c.RestoreVector(l.Point),
+	}
+}
+
+func (c *CoordSystem) restoreRotation(v Vec3) Vec3 {
+	return c.v1.Mul(v.X).Add(c.v2.Mul(v.Y)).Add(c.v3.Mul(v.Z))
+}
+
 func (c *CoordSystem) rotate(v Vec3) Vec3 {
 	return Vec3{
 		v.Dot(c.v1),
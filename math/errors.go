@@ -0,0 +1,12 @@
+package math
+
+import "errors"
+
+var (
+	// ErrNullVector возвращается, если направляющий вектор или нормаль нулевые.
+	ErrNullVector = errors.New("null vector")
+	// ErrParallel возвращается, если пересекаемые объекты параллельны.
+	ErrParallel = errors.New("objects are parallel")
+	// ErrNoCrossing возвращается, если точка пересечения лежит вне фигуры.
+	ErrNoCrossing = errors.New("no crossing point")
+)
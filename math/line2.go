@@ -1,9 +1,5 @@
 package math
 
-import (
-	"errors"
-)
-
 //Line2 представляет линию в двухмерном простарнстве ( Ortho*r + Dist = 0).
 type Line2 struct {
 	Ortho Vec2
@@ -48,7 +44,7 @@ func NewLine2Vec(pt, vec Vec2) Line2 {
 func (l *Line2) Cross(ol Line2) (Vec2, error) {
 	t := ol.Ortho.Y - ol.Ortho.X/l.Ortho.X*l.Ortho.Y
 	if t == 0 {
-		return Vec2{0, 0}, errors.New("Dummy")
+		return Vec2{0, 0}, ErrParallel
 	}
 	y := -(ol.Ortho.X/l.Ortho.X*l.Dist + ol.Dist) / t
 	x := (l.Dist - l.Ortho.Y*y) / l.Ortho.X
@@ -56,7 +52,8 @@ func (l *Line2) Cross(ol Line2) (Vec2, error) {
 }
 
 //Vectors возращает точку, через которую проходит l, и напаравляющий вектор l.
-func (l *Line2) Vectors() (point Vec2, vector Vec2) {
+// Если нормаль прямой нулевая, возвращает ErrNullVector.
+func (l *Line2) Vectors() (point Vec2, vector Vec2, err error) {
 	vector = l.Ortho.Ortho().Ort()
 	switch {
 	case l.Ortho.Y != 0:
@@ -64,7 +61,7 @@ func (l *Line2) Vectors() (point Vec2, vector Vec2) {
 	case l.Ortho.X != 0:
 		point = Vec2{Y: 0, X: -l.Dist / l.Ortho.X}
 	default:
-		panic("Vectors null vector")
+		err = ErrNullVector
 	}
 	return
 }
@@ -0,0 +1,77 @@
+package math
+
+import (
+	"math"
+	"sort"
+)
+
+// Minimize ищет минимум функции f методом Нелдера-Мида, начиная с точки x0.
+// step задает размер начального симплекса по каждой координате.
+// Возвращает найденную точку минимума и значение функции в ней.
+func Minimize(f func(x []float64) float64, x0, step []float64, maxIter int, tol float64) ([]float64, float64) {
+	const (
+		alpha = 1.0
+		gamma = 2.0
+		rho   = 0.5
+		sigma = 0.5
+	)
+	n := len(x0)
+	type vertex struct {
+		x []float64
+		f float64
+	}
+	simplex := make([]vertex, n+1)
+	for i := range simplex {
+		x := make([]float64, n)
+		copy(x, x0)
+		if i > 0 {
+			x[i-1] += step[i-1]
+		}
+		simplex[i] = vertex{x, f(x)}
+	}
+	point := func(c, d []float64, k float64) []float64 {
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = c[i] + k*(d[i]-c[i])
+		}
+		return x
+	}
+	for iter := 0; iter < maxIter; iter++ {
+		sort.Slice(simplex, func(i, j int) bool { return simplex[i].f < simplex[j].f })
+		best, worst := simplex[0], simplex[n]
+		if math.Abs(worst.f-best.f) <= tol*(math.Abs(best.f)+math.Abs(worst.f))+1e-300 {
+			break
+		}
+		centroid := make([]float64, n)
+		for _, v := range simplex[:n] {
+			for i := range centroid {
+				centroid[i] += v.x[i] / float64(n)
+			}
+		}
+		xr := point(centroid, worst.x, -alpha)
+		fr := f(xr)
+		switch {
+		case fr < best.f:
+			xe := point(centroid, worst.x, -gamma)
+			if fe := f(xe); fe < fr {
+				simplex[n] = vertex{xe, fe}
+			} else {
+				simplex[n] = vertex{xr, fr}
+			}
+		case fr < simplex[n-1].f:
+			simplex[n] = vertex{xr, fr}
+		default:
+			xc := point(centroid, worst.x, rho)
+			if fc := f(xc); fc < worst.f {
+				simplex[n] = vertex{xc, fc}
+				continue
+			}
+			for i := 1; i <= n; i++ {
+				x := point(best.x, simplex[i].x, sigma)
+				simplex[i] = vertex{x, f(x)}
+			}
+		}
+	}
+	sort.Slice(simplex, func(i, j int) bool { return simplex[i].f < simplex[j].f })
+	return simplex[0].x, simplex[0].f
+}
@@ -0,0 +1,16 @@
+package math
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMinimize(t *testing.T) {
+	rosenbrock := func(x []float64) float64 {
+		return math.Pow(1-x[0], 2) + 100*math.Pow(x[1]-x[0]*x[0], 2)
+	}
+	x, f := Minimize(rosenbrock, []float64{-1.2, 1}, []float64{0.5, 0.5}, 10000, 1e-14)
+	if math.Abs(x[0]-1) > 1e-3 || math.Abs(x[1]-1) > 1e-3 {
+		t.Errorf("Minimize(rosenbrock) == %v, %v", x, f)
+	}
+}
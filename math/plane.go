@@ -1,9 +1,5 @@
 package math
 
-import (
-	"errors"
-)
-
 type Plane struct {
 	Norm Vec3
 	Dist float64
@@ -21,8 +17,28 @@ func NewPlane(dot1, dot2, dot3 Vec3) Plane {
 func (p *Plane) Cross(l Line3) (Vec3, error) {
 	d := p.Norm.Dot(l.Vector)
 	if d == 0 {
-		return Vec3{0, 0, 0}, errors.New("Line is parallel to plane")
+		return Vec3{0, 0, 0}, ErrParallel
 	}
 	t := -(p.Norm.Dot(l.Point) + p.Dist) / d
 	return l.Vector.Mul(t).Add(l.Point), nil
 }
+
+// NewPlaneNorm создает плоскость по нормали norm и точке pt, лежащей в плоскости.
+func NewPlaneNorm(norm, pt Vec3) Plane {
+	norm = norm.Ort()
+	return Plane{
+		norm,
+		-norm.Dot(pt),
+	}
+}
+
+// CrossPlane возвращает прямую пересечения плоскостей p и op.
+func (p *Plane) CrossPlane(op Plane) (Line3, error) {
+	u := p.Norm.Cross(op.Norm)
+	l := u.Dot(u)
+	if l == 0 {
+		return Line3{}, ErrParallel
+	}
+	pt := op.Norm.Cross(u).Mul(-p.Dist).Add(u.Cross(p.Norm).Mul(-op.Dist)).Mul(1 / l)
+	return Line3{Point: pt, Vector: u.Ort()}, nil
+}
@@ -12,25 +12,29 @@ func NewQuadrangle2(v []Vec2) Quadrangle2 {
 	}
 }
 
-func getT(pt, p, v Vec2) float64 {
+func getT(pt, p, v Vec2) (float64, error) {
 	switch {
 	case v.X != 0:
-		return (pt.X - p.X) / v.X
+		return (pt.X - p.X) / v.X, nil
 	case v.Y != 0:
-		return (pt.Y - p.Y) / v.Y
+		return (pt.Y - p.Y) / v.Y, nil
 	}
-	panic("getT null vector")
+	return 0, ErrNullVector
 }
 
+// Cross возвращает точки пересечения прямой l со сторонами q. Вырожденные стороны пропускаются.
 func (q *Quadrangle2) Cross(l Line2) (crosses []Vec2) {
 	for i := 0; i < 4; i++ {
 		j := (i + 1) % 4
 		ol := NewLine2Points(q.Vertices[i], q.Vertices[j])
 		if crossPoint, err := l.Cross(ol); err == nil {
-			pt, vec := ol.Vectors()
-			cT := getT(crossPoint, pt, vec)
-			iT := getT(q.Vertices[i], pt, vec)
-			jT := getT(q.Vertices[j], pt, vec)
+			pt, vec, err := ol.Vectors()
+			if err != nil {
+				continue
+			}
+			cT, _ := getT(crossPoint, pt, vec)
+			iT, _ := getT(q.Vertices[i], pt, vec)
+			jT, _ := getT(q.Vertices[j], pt, vec)
 			if iT <= cT && cT <= jT || jT <= cT && cT <= iT {
 				crosses = append(crosses, crossPoint)
 			}
@@ -37,3 +37,13 @@ func TestQuadrangle2HasPoint(t *testing.T) {
 		t.Error("rect.HasPoint(Vec2{0,-5}) == true")
 	}
 }
+
+func TestQuadrangle2CrossDegenerate(t *testing.T) {
+	var l Line2
+	if _, _, err := l.Vectors(); err != ErrNullVector {
+		t.Errorf("l.Vectors() error == %v", err)
+	}
+	// Совпадающие вершины не должны приводить к панике.
+	q := NewQuadrangle2([]Vec2{{0, 0}, {0, 0}, {10, 10}, {10, 0}})
+	q.Cross(NewLine2KB(1, -5))
+}
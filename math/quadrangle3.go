@@ -1,9 +1,5 @@
 package math
 
-import (
-	"errors"
-)
-
 type Quadrangle3 struct {
 	Vertices [4]Vec3
 	coord    CoordSystem
@@ -28,8 +24,11 @@ func (q *Quadrangle3) HasPoint(p Vec3) bool {
 
 func (q *Quadrangle3) Cross(l Line3) (Vec3, error) {
 	c, err := q.plane.Cross(l)
-	if err != nil || !q.HasPoint(c) {
-		return Vec3{0, 0, 0}, errors.New("(q Quadrangle3) Cross")
+	if err != nil {
+		return Vec3{0, 0, 0}, err
+	}
+	if !q.HasPoint(c) {
+		return Vec3{0, 0, 0}, ErrNoCrossing
 	}
 	return c, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -171,67 +172,144 @@ func readDecorTracks(filname string) (map[uint][]math.Line3, error) {
 	return events, nil
 }
 
-func ctudcReader(dirname string) (<-chan trek.Event, error) {
-	fileList, err := ioutil.ReadDir(dirname)
+// scanValid считывает следующее событие сканера s, пропуская события с недопустимыми хитами.
+// При остальных ошибках возвращает false, ошибка доступна через s.Err.
+func scanValid(s *trek.Scanner) bool {
+	for !s.Scan() {
+		err := s.Err()
+		if err == nil || !errors.Is(err, trek.ErrInvalidWire) {
+			return false
+		}
+		log.Println("Skipping event:", err)
+	}
+	return true
+}
+
+// ctudcReader читает события КТУДК из файлов .tds директории dirname в порядке их номеров.
+// События с недопустимыми хитами пропускаются; при остальных ошибках чтение прекращается,
+// и ошибка возвращается Err потока. Поток должен быть закрыт Close.
+func ctudcReader(ctx context.Context, dirname string) (*ctudcStream, error) {
+	set, err := readFileSet(dirname, ".tds")
 	if err != nil {
 		return nil, err
 	}
+	set.logGaps()
 	c := make(chan trek.Event, 100)
-	go func() {
-		for _, fileStat := range fileList {
-			if filepath.Ext(fileStat.Name()) != ".tds" {
-				continue
-			}
-			f, err := os.Open(filepath.Join(dirname, fileStat.Name()))
-			log.Println("Opening file: ", fileStat.Name())
-			if err != nil {
-				continue
-			}
-			s, err := trek.NewScanner(f)
-			if err != nil {
-				panic("failed create scanner: " + err.Error())
+	ctx, state := newStreamState(ctx)
+	readFile := func(filename string) error {
+		log.Println("Opening file: ", filepath.Base(filename))
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		s, err := trek.NewScanner(f)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filepath.Base(filename), err)
+		}
+		s.SetName(filepath.Base(filename))
+		for scanValid(s) {
+			select {
+			case c <- s.Record().Copy():
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			for s.Scan() {
-				c <- s.Record().Copy()
+		}
+		return s.Err()
+	}
+	go func() {
+		var err error
+		for _, filename := range set.Paths() {
+			if err = readFile(filename); err != nil {
+				break
 			}
-			f.Close()
 		}
+		state.finish(err)
 		close(c)
 	}()
-	return c, nil
+	return &ctudcStream{C: c, streamState: state}, nil
+}
+
+// Порог амплитуд ФЭУ в сигмах пьедестала при расчете энерговыделения.
+const depositSigmas = 3
+
+// nevodRecord содержит метаданные события НЕВОД, треки ДЕКОР, восстановленные по стрипам,
+// и полные данные события.
+type nevodRecord struct {
+	Meta  nevod.EventMeta
+	Time  time.Time
+	Decor []trek.DecorTrack
+	Full  *trek.NevodData
 }
 
-func nevodReader(dirname string) (<-chan nevod.EventMeta, error) {
-	fileList, err := ioutil.ReadDir(dirname)
+// decorTracks восстанавливает треки ДЕКОР последнего события сканера s.
+func decorTracks(s *nevod.Scanner) ([]trek.DecorTrack, error) {
+	d := s.Decor()
+	hits, err := d.Hits()
 	if err != nil {
 		return nil, err
 	}
-	c := make(chan nevod.EventMeta, 100)
-	go func() {
-		for _, fileStat := range fileList {
-			if filepath.Ext(fileStat.Name()) != ".nad" {
-				continue
+	var tracks []trek.DecorTrack
+	for _, t := range nevod.FindTracks(hits, &d.Conf) {
+		tracks = append(tracks, trek.DecorTrack{Type: t.Type, Track: t.Line})
+	}
+	return tracks, nil
+}
+
+// nevodReader читает события НЕВОД из файлов .nad директории dirname в порядке их номеров.
+// Если withDecor, для каждого события восстанавливаются треки ДЕКОР.
+// Если withFull, передаются полные данные событий с энерговыделением.
+// Ошибка чтения возвращается Err потока. Поток должен быть закрыт Close.
+func nevodReader(ctx context.Context, dirname string, withDecor, withFull bool) (*nevodStream, error) {
+	set, err := readFileSet(dirname, ".nad")
+	if err != nil {
+		return nil, err
+	}
+	set.logGaps()
+	r, err := set.Open()
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan nevodRecord, 100)
+	ctx, state := newStreamState(ctx)
+	read := func() error {
+		defer r.Close()
+		s := nevod.NewScanner(r)
+		for s.Scan() {
+			record := nevodRecord{Meta: s.Record().Meta, Time: s.Any().Date.Time()}
+			if withDecor {
+				var err error
+				if record.Decor, err = decorTracks(s); err != nil {
+					log.Printf("Failed decode decor event %d: %s\n", record.Meta.Nevent, err)
+				}
 			}
-			f, err := os.Open(filepath.Join(dirname, fileStat.Name()))
-			if err != nil {
-				continue
+			if withFull {
+				event := s.Record()
+				record.Full = &trek.NevodData{
+					Event:   *event,
+					Deposit: event.Deposit(s.Pedestals(), depositSigmas),
+				}
 			}
-			s := nevod.NewScanner(f)
-			for s.Scan() {
-				c <- s.Record().Meta
+			select {
+			case c <- record:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			f.Close()
 		}
+		return s.Error()
+	}
+	go func() {
+		state.finish(read())
 		close(c)
 	}()
-	return c, nil
+	return &nevodStream{C: c, streamState: state}, nil
 }
 
 func mergeRun(run int) error {
 	root := formatRunDir(run)
 	ctudc := formatCtudcSubdir(run)
 	nevod := formatNevodRunDir(run)
-	extData := filepath.Join(root, fmt.Sprintf("extctudc_%05d.tds", run))
+	extData := formatExtFilename(run)
 	decor := filepath.Join(root, "decor.dat")
 	decorShSh := filepath.Join(root, "decor_shsh.dat")
 	meta, err := readRunMeta(run)
@@ -241,39 +319,54 @@ func mergeRun(run int) error {
 		return fmt.Errorf("failed read nevod run meta %v", err)
 	}
 
-	decorTracks, err := readDecorTracks(decor)
-	if err != nil {
-		return fmt.Errorf("Failed read decor tracks: %s", err)
-	}
-	decorTracksShSh, err := readDecorTracks(decorShSh)
-	if err != nil {
-		return fmt.Errorf("Failed read ShSh decor tracks: %s", err)
+	native := *decorSource == "nad"
+	var allDecorTracks, decorTracksShSh map[uint][]math.Line3
+	if !native {
+		if allDecorTracks, err = readDecorTracks(decor); err != nil {
+			return fmt.Errorf("Failed read decor tracks: %s", err)
+		}
+		if decorTracksShSh, err = readDecorTracks(decorShSh); err != nil {
+			return fmt.Errorf("Failed read ShSh decor tracks: %s", err)
+		}
 	}
-	ctudcStream, err := ctudcReader(ctudc)
+	ctx := context.Background()
+	ctudcStream, err := ctudcReader(ctx, ctudc)
 	if err != nil {
 		return fmt.Errorf("Failed open ctudc data: %s", err)
 	}
-	nevodStream, err := nevodReader(nevod)
+	defer ctudcStream.Close()
+	nevodStream, err := nevodReader(ctx, nevod, native, *fullNevod)
 	if err != nil {
 		return fmt.Errorf("Failed open nevod data: %s", err)
 	}
-	f, err := os.Create(extData)
+	defer nevodStream.Close()
+	w, err := createData(extData, *compression)
 	if err != nil {
 		return fmt.Errorf("Failed create output file: %s", err)
 	}
-	w := bufio.NewWriter(f)
-	defer f.Close()
-	defer w.Flush()
-	w.WriteString("TDSext_m\n")
+	// При ошибке неполные данные не заменяют существующий файл.
+	defer w.Abort()
+	flags := trek.FlagNevod | trek.FlagDecor
+	if *fullNevod {
+		flags |= trek.FlagFullNevod
+	}
+	if w.Compressed() {
+		flags |= trek.FlagCompressed
+	}
+	w.WriteString(trek.NewFileHeader(trek.FormatExt, producer(), run, flags).String() + "\n")
 	if err := meta.Marshal(w); err != nil {
 		return fmt.Errorf("failed marshal file header %v", err)
 	}
-	for ctudcEvent := range ctudcStream {
-		nrun, nevent := ctudcEvent.Nrun(), ctudcEvent.Nevent()
-		var decor []trek.DecorTrack
-
-		if allTracks, ok := decorTracks[nevent]; ok {
-			shTracks := decorTracksShSh[nevent]
+	matcher := newEventMatcher(nevodStream, run, *matchWindow, *matchOffset)
+	for ctudcEvent := range ctudcStream.C {
+		record := matcher.match(&ctudcEvent)
+		if record == nil {
+			continue
+		}
+		decor := record.Decor
+		// Треки в decor.dat пронумерованы по событиям НЕВОД.
+		if allTracks, ok := allDecorTracks[uint(record.Meta.Nevent)]; ok {
+			shTracks := decorTracksShSh[uint(record.Meta.Nevent)]
 			for i := range allTracks {
 				var trackType int8
 				for j := range shTracks {
@@ -288,35 +381,53 @@ func mergeRun(run int) error {
 				})
 			}
 		}
-
-		for nevodMeta := range nevodStream {
-			if nrun != uint(nevodMeta.Nrun) {
-				log.Fatalln("OOps!! invalid run number!! data is corrupted")
-			}
-			if nevent == uint(nevodMeta.Nevent) {
-				extEvent := trek.ExtEvent{
-					Ctudc: ctudcEvent,
-					Nevod: nevodMeta,
-					Decor: decor,
-				}
-				extEvent.Marshal(w)
-				break
-			} else if uint(nevodMeta.Nevent) > nevent {
-				break
-			}
+		extEvent := trek.ExtEvent{
+			Ctudc: ctudcEvent,
+			Nevod: record.Meta,
+			Decor: decor,
+			Full:  record.Full,
+		}
+		if *fullNevod {
+			err = extEvent.MarshalFull(w)
+		} else {
+			err = extEvent.Marshal(w)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed write event: %s", err)
 		}
 	}
+	if err := ctudcStream.Err(); err != nil {
+		return fmt.Errorf("Failed read ctudc data: %s", err)
+	}
+	report := matcher.finish()
+	if err := nevodStream.Err(); err != nil {
+		return fmt.Errorf("Failed read nevod data: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Failed write output file: %s", err)
+	}
+	log.Printf("Run %d: matched %d, ctudc only %d, nevod only %d, foreign %d\n",
+		run, report.matched, report.ctudcOnly, report.nevodOnly, report.foreign)
+	if err := report.write(filepath.Join(root, "match_report.dat")); err != nil {
+		return fmt.Errorf("Failed write match report: %s", err)
+	}
 	return nil
 }
 
+// merge объединяет данные КТУДК и НЕВОД ранов runs.
+// Треки ДЕКОР берутся из decor.dat и decor_shsh.dat или, если decorSource == "nad", восстанавливаются по файлам NAD.
 func merge(runs []int) error {
-	for _, run := range runs {
-		log.Print("Processing ", formatRunDir(run))
-		if err := mergeRun(run); err != nil {
-			log.Println(" failed:", err)
-		} else {
-			log.Println(" success")
-		}
+	if err := checkCompression(*compression); err != nil {
+		return err
 	}
-	return nil
+	if *decorSource != "file" && *decorSource != "nad" {
+		return fmt.Errorf("invalid decor source %q", *decorSource)
+	}
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Processing ", formatRunDir(run))
+		return nil, mergeRun(run)
+	}, func(*runResult) error {
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/frostoov/CtudcHandler/nevod"
+)
+
+// Формат времени в файлах мониторинга.
+const monitorTimeLayout = "2006-01-02T15:04:05.00"
+
+// monitorWriter содержит выходные файлы мониторинга одного рана.
+type monitorWriter struct {
+	files                    []*os.File
+	pedestals, noise, blocks *bufio.Writer
+}
+
+func newMonitorWriter(outdir string, run int) (*monitorWriter, error) {
+	m := new(monitorWriter)
+	create := func(name, header string) (*bufio.Writer, error) {
+		f, err := os.Create(filepath.Join(outdir, fmt.Sprintf("%s_%05d.dat", name, run)))
+		if err != nil {
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		w := bufio.NewWriter(f)
+		fmt.Fprintln(w, header)
+		return w, nil
+	}
+	var err error
+	if m.pedestals, err = create("pedestals", fmt.Sprintf("# %22s\t%4s\t%4s\t%4s\t%4s\t%8s\t%8s\t%8s\t%8s",
+		"time", "det", "blk", "ksm", "pmt", "ped12", "sigma12", "ped9", "sigma9")); err != nil {
+		m.Close()
+		return nil, err
+	}
+	if m.noise, err = create("noise", fmt.Sprintf("# %22s\t%4s\t%4s\t%4s\t%4s\t%8s",
+		"time", "det", "blk", "ksm", "pmt", "kHz")); err != nil {
+		m.Close()
+		return nil, err
+	}
+	if m.blocks, err = create("blocks", fmt.Sprintf("# %22s\t%4s\t%4s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s",
+		"time", "det", "blk", "Tout", "T3", "T2", "T1", "V1", "V2", "Vcc", "V3", "V4")); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *monitorWriter) Close() error {
+	var firstErr error
+	for _, w := range []*bufio.Writer{m.pedestals, m.noise, m.blocks} {
+		if w != nil {
+			if err := w.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	for _, f := range m.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write записывает данные записи мониторинга r.
+func (m *monitorWriter) write(r *nevod.Record) {
+	switch v := r.Value.(type) {
+	case *nevod.MonitDat:
+		if r.Type != nevod.RecPedestals {
+			return
+		}
+		for bek := range v.MonPds {
+			if v.MaskBek&(1<<uint(bek)) == 0 {
+				continue
+			}
+			p := &v.MonPds[bek]
+			for ksm := range p.Sred {
+				for pmt := range p.Sred[ksm] {
+					if p.MaskPMT[ksm]&(1<<uint(pmt)) == 0 {
+						continue
+					}
+					fmt.Fprintf(m.pedestals, "%24s\t%4s\t%4d\t%4d\t%4d\t%8.2f\t%8.2f\t%8.2f\t%8.2f\n",
+						p.Date.Time().Format(monitorTimeLayout), "ksm", bek+1, ksm+1, pmt+1,
+						p.Sred[ksm][pmt][nevod.Dinode12], p.Sigma[ksm][pmt][nevod.Dinode12],
+						p.Sred[ksm][pmt][nevod.Dinode9], p.Sigma[ksm][pmt][nevod.Dinode9])
+				}
+			}
+		}
+	case *nevod.MonitSct:
+		for bep := range v.MonPds {
+			if v.MaskBep&(1<<uint(bep)) == 0 {
+				continue
+			}
+			p := &v.MonPds[bep]
+			for ksm := range p.Sred {
+				for pmt := range p.Sred[ksm] {
+					if p.MaskPMT[ksm]&(1<<uint(pmt)) == 0 {
+						continue
+					}
+					fmt.Fprintf(m.pedestals, "%24s\t%4s\t%4d\t%4d\t%4d\t%8.2f\t%8.2f\t%8s\t%8s\n",
+						p.Date.Time().Format(monitorTimeLayout), "sct", bep+1, ksm+1, pmt+1,
+						p.Sred[ksm][pmt], p.Sigma[ksm][pmt], "-", "-")
+				}
+			}
+		}
+	case *nevod.MonitShumTV:
+		for bek := range v.ShumTV {
+			if v.MaskBek&(1<<uint(bek)) == 0 {
+				continue
+			}
+			s := &v.ShumTV[bek]
+			date := s.Date.Time().Format(monitorTimeLayout)
+			for ksm := range s.NoisePMT {
+				for pmt := range s.NoisePMT[ksm] {
+					if s.MaskPMT[ksm]&(1<<uint(pmt)) != 0 {
+						fmt.Fprintf(m.noise, "%24s\t%4s\t%4d\t%4d\t%4d\t%8.3f\n", date, "ksm", bek+1, ksm+1, pmt+1, s.NoisePMT[ksm][pmt])
+					}
+				}
+			}
+			m.writeBlock(date, "ksm", bek, s.Tbek, s.Vbek)
+		}
+	case *nevod.MonitShumTvSct:
+		for bep := range v.ShumTV {
+			if v.MaskBep&(1<<uint(bep)) == 0 {
+				continue
+			}
+			s := &v.ShumTV[bep]
+			date := s.Date.Time().Format(monitorTimeLayout)
+			for ksm := range s.NoisePmt {
+				for pmt := range s.NoisePmt[ksm] {
+					if s.MaskPmt[ksm]&(1<<uint(pmt)) != 0 {
+						fmt.Fprintf(m.noise, "%24s\t%4s\t%4d\t%4d\t%4d\t%8.3f\n", date, "sct", bep+1, ksm+1, pmt+1, s.NoisePmt[ksm][pmt])
+					}
+				}
+			}
+			m.writeBlock(date, "sct", bep, s.Tbek, s.Vbek)
+		}
+	}
+}
+
+func (m *monitorWriter) writeBlock(date, det string, block int, t [4]float32, v [5]float32) {
+	fmt.Fprintf(m.blocks, "%24s\t%4s\t%4d", date, det, block+1)
+	for _, x := range t {
+		fmt.Fprintf(m.blocks, "\t%8.2f", x)
+	}
+	for _, x := range v {
+		fmt.Fprintf(m.blocks, "\t%8.3f", x)
+	}
+	fmt.Fprintln(m.blocks)
+}
+
+// monitorRun записывает временные ряды мониторинга НЕВОД рана run в outdir.
+func monitorRun(outdir string, run int) error {
+	set, err := readFileSet(formatNevodRunDir(run), ".nad")
+	if err != nil {
+		return fmt.Errorf("Failed open nevod data: %s", err)
+	}
+	set.logGaps()
+	m, err := newMonitorWriter(outdir, run)
+	if err != nil {
+		return fmt.Errorf("Failed create monitor files: %s", err)
+	}
+	r, err := set.Open()
+	if err != nil {
+		m.Close()
+		return fmt.Errorf("Failed open nevod data: %s", err)
+	}
+	defer r.Close()
+	s := nevod.NewScanner(r)
+	for s.ScanAny() {
+		m.write(s.Any())
+	}
+	if err := s.Error(); err != nil {
+		m.Close()
+		return err
+	}
+	return m.Close()
+}
+
+// nevodMonitor записывает временные ряды пьедесталов, шумов, температур и напряжений НЕВОД ранов runs.
+func nevodMonitor(runs []int) error {
+	outdir := outputPath("monitor")
+	if err := os.MkdirAll(outdir, 0777); err != nil {
+		return fmt.Errorf("Failed create output dir: %s", err)
+	}
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Processing ", formatNevodRunDir(run))
+		return nil, monitorRun(outdir, run)
+	}, func(*runResult) error {
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
+}
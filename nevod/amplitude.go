@@ -0,0 +1,96 @@
+package nevod
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// Dinode12 индекс кода АЦП 12 динода.
+	Dinode12 = 0
+	// Dinode9 индекс кода АЦП 9 динода.
+	Dinode9 = 1
+)
+
+// Pedestals содержит последние измеренные пьедесталы БЭК.
+type Pedestals struct {
+	// Признак наличия пьедесталов БЭК
+	Valid [MAXBEK]bool
+	// Результаты мониторинга пьедесталов БЭК
+	Bek [MAXBEK]SMonADC
+}
+
+// Update обновляет пьедесталы БЭК, присутствующих в записи мониторинга m.
+func (p *Pedestals) Update(m *MonitDat) {
+	for _, bek := range maskBits(m.MaskBek) {
+		p.Bek[bek], p.Valid[bek] = m.MonPds[bek], true
+	}
+}
+
+// Amplitude содержит амплитуду сработавшего ФЭУ КСМ за вычетом пьедестала.
+type Amplitude struct {
+	Bek, Ksm, Pmt int
+	// Амплитуды 12 и 9 динодов в кодах АЦП
+	Dinode [2]float64
+	// Сигмы пьедесталов 12 и 9 динодов
+	Sigma [2]float64
+}
+
+// Amplitudes возвращает амплитуды сработавших ФЭУ КСМ события e за вычетом пьедесталов p.
+// Данные БЭК, для которых нет пьедесталов, и данные СКТ пропускаются.
+func (e *Event) Amplitudes(p *Pedestals) []Amplitude {
+	var amps []Amplitude
+	beks := maskBits(e.Meta.MaskBek)
+	for i := 0; i < int(e.Meta.Nbek) && i < len(beks); i++ {
+		bek, data := beks[i], &e.EventBek[i]
+		if !p.Valid[bek] || data.IDbek[1] != 0 {
+			continue
+		}
+		ped := &p.Bek[bek]
+		for ksm := range data.Acp {
+			if data.MaskKSM&(1<<uint(ksm)) == 0 {
+				continue
+			}
+			for pmt := range data.Acp[ksm] {
+				if data.MaskHit[ksm]&(1<<uint(pmt)) == 0 {
+					continue
+				}
+				amp := Amplitude{Bek: bek, Ksm: ksm, Pmt: pmt}
+				for d := range amp.Dinode {
+					amp.Dinode[d] = float64(data.Acp[ksm][pmt][d]) - float64(ped.Sred[ksm][pmt][d])
+					amp.Sigma[d] = float64(ped.Sigma[ksm][pmt][d])
+				}
+				amps = append(amps, amp)
+			}
+		}
+	}
+	return amps
+}
+
+// Deposit возвращает суммарное энерговыделение события e в кодах АЦП 12 и 9 динодов.
+// Учитываются амплитуды, превышающие пьедестал более чем на nsigma сигм.
+func (e *Event) Deposit(p *Pedestals, nsigma float64) [2]float64 {
+	var sum [2]float64
+	for _, amp := range e.Amplitudes(p) {
+		for d := range sum {
+			if amp.Dinode[d] > nsigma*amp.Sigma[d] {
+				sum[d] += amp.Dinode[d]
+			}
+		}
+	}
+	return sum
+}
+
+// Marshal совершает бинарный маршалинг события e.
+func (e *Event) Marshal(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, &e.Meta); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, e.EventBek[:e.Meta.Nbek]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, e.EventBep[:e.Meta.Nbep]); err != nil {
+		return err
+	}
+	return nil
+}
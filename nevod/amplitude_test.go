@@ -0,0 +1,56 @@
+package nevod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEventDeposit(t *testing.T) {
+	var ped SMonADC
+	for ksm := range ped.Sred {
+		for pmt := range ped.Sred[ksm] {
+			ped.Sred[ksm][pmt] = [2]float32{100, 50}
+			ped.Sigma[ksm][pmt] = [2]float32{2, 1}
+		}
+	}
+	// Запись мониторинга в порядке битов маски: БЭК 3 и 5.
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1<<3|1<<5))
+	binary.Write(&buf, binary.LittleEndian, int16(2))
+	binary.Write(&buf, binary.LittleEndian, [2]SMonADC{ped, ped})
+
+	m, ok := unmarshalRecord(RecPedestals, buf.Bytes()).(*MonitDat)
+	if !ok {
+		t.Fatal("failed unmarshal monpds record")
+	}
+	var p Pedestals
+	p.Update(m)
+	if !p.Valid[3] || !p.Valid[5] || p.Valid[0] || p.Valid[4] {
+		t.Fatalf("valid pedestals == %v", p.Valid)
+	}
+	if _, ok := unmarshalRecord(RecPedestals, buf.Bytes()[:20]).([]byte); !ok {
+		t.Error("truncated record is decoded")
+	}
+
+	var e Event
+	e.Meta.MaskBek = 1<<3 | 1<<4
+	e.Meta.Nbek = 2
+	for i := 0; i < 2; i++ {
+		bek := &e.EventBek[i]
+		bek.MaskKSM = 1
+		bek.MaskHit[0] = 1<<0 | 1<<1
+		bek.Acp[0][0] = [2]uint16{300, 60}
+		bek.Acp[0][1] = [2]uint16{104, 51}
+	}
+	amps := e.Amplitudes(&p)
+	if len(amps) != 2 {
+		t.Fatalf("amplitudes == %v", amps)
+	}
+	if amps[0].Bek != 3 || amps[0].Dinode != [2]float64{200, 10} {
+		t.Errorf("amplitudes[0] == %v", amps[0])
+	}
+	if deposit := e.Deposit(&p, 3); deposit != [2]float64{200, 10} {
+		t.Errorf("deposit == %v", deposit)
+	}
+}
@@ -0,0 +1,170 @@
+package nevod
+
+import (
+	"errors"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+// Projection проекция стрипа ДЕКОР.
+type Projection uint8
+
+const (
+	// ProjX стрип камеры X.
+	ProjX Projection = iota
+	// ProjY стрип камеры Y.
+	ProjY
+)
+
+func (p Projection) String() string {
+	if p == ProjX {
+		return "X"
+	}
+	return "Y"
+}
+
+// NPLANE кол-во плоскостей (каналов) в одном супермодуле.
+const NPLANE = NCNTR * NCHAN
+
+// ErrDecorTruncated возвращается при выходе упакованных данных за пределы буфера события.
+var ErrDecorTruncated = errors.New("decor: packed data is truncated")
+
+// StripHit содержит сработавший стрип ДЕКОР.
+type StripHit struct {
+	Module int        // номер супермодуля (ПМ)
+	Plane  int        // номер плоскости в супермодуле (канал контроллера)
+	Proj   Projection // проекция стрипа
+	Strip  int        // номер стрипа в проекции
+	Pos    geo.Vec3   // координаты стрипа в системе НЕВОД
+}
+
+// PlaneHits содержит сработавшие стрипы одной плоскости.
+type PlaneHits struct {
+	X []StripHit
+	Y []StripHit
+}
+
+// ModuleHits содержит сработавшие стрипы одного супермодуля в формате [plane]PlaneHits.
+type ModuleHits [NPLANE]PlaneHits
+
+// Strips распаковывает сработавшие стрипы события e.
+//
+// Данные упакованы последовательно для каждого контроллера k, отмеченного в MaskCntr,
+// и каждого его канала c, отмеченного в MaskaLamChan (бит k*NCHAN+c).
+// Для канала записана маска масок: 8 бит (16 бит, если установлен бит k в MaskLenMaskMask),
+// бит g которой означает наличие группы g из 8 байт. Для каждой группы записана
+// маска ненулевых байт, за которой следуют сами ненулевые байты.
+// Бит b байта n канала соответствует стрипу n*8+b: первые Nx стрипов - камеры X, следующие Ny - камеры Y.
+// Координаты стрипов вычисляются по конфигурации каналов conf.
+func (e *DecorEvent) Strips(conf *[MAXPM * NPLANE]ConfChannel) ([]StripHit, error) {
+	meta := &e.Meta
+	if meta.LenAllData < 0 || int(meta.LenAllData) > len(e.Buf) {
+		return nil, ErrDecorTruncated
+	}
+	buf := e.Buf[:meta.LenAllData]
+	pos := 0
+	next := func() (uint8, error) {
+		if pos >= len(buf) {
+			return 0, ErrDecorTruncated
+		}
+		pos++
+		return buf[pos-1], nil
+	}
+
+	var hits []StripHit
+	for k := 0; k < MAXPM*NCNTR; k++ {
+		if meta.MaskCntr&(1<<uint(k)) == 0 {
+			continue
+		}
+		for c := 0; c < NCHAN; c++ {
+			lam := k*NCHAN + c
+			if meta.MaskaLamChan[lam/8]&(1<<uint(lam%8)) == 0 {
+				continue
+			}
+			lo, err := next()
+			if err != nil {
+				return nil, err
+			}
+			groups := uint16(lo)
+			if meta.MaskLenMaskMask&(1<<uint(k)) != 0 {
+				hi, err := next()
+				if err != nil {
+					return nil, err
+				}
+				groups |= uint16(hi) << 8
+			}
+			ch := &conf[lam]
+			for g := uint(0); g < NGROUP64; g++ {
+				if groups&(1<<g) == 0 {
+					continue
+				}
+				mask, err := next()
+				if err != nil {
+					return nil, err
+				}
+				for n := uint(0); n < 8; n++ {
+					if mask&(1<<n) == 0 {
+						continue
+					}
+					data, err := next()
+					if err != nil {
+						return nil, err
+					}
+					if ch.Include == 0 {
+						continue
+					}
+					for b := uint(0); b < 8; b++ {
+						if data&(1<<b) != 0 {
+							if hit, ok := ch.strip(int((g*8+n)*8 + b)); ok {
+								hit.Module, hit.Plane = lam/NPLANE, lam%NPLANE
+								hits = append(hits, hit)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return hits, nil
+}
+
+// strip возвращает стрип с номером bit в данных канала.
+// Если bit выходит за пределы камер канала, возвращает false.
+func (c *ConfChannel) strip(bit int) (StripHit, bool) {
+	nx, ny := int(c.Nx), int(c.Ny)
+	switch {
+	case bit < nx:
+		origin := geo.Vec3{X: float64(c.Xx), Y: float64(c.Yx), Z: float64(c.Zx)}
+		step := geo.Vec3{X: float64(c.VXx), Y: float64(c.VYx), Z: float64(c.VZx)}
+		return StripHit{Proj: ProjX, Strip: bit, Pos: origin.Add(step.Mul(float64(bit)))}, true
+	case bit < nx+ny:
+		bit -= nx
+		origin := geo.Vec3{X: float64(c.Xy), Y: float64(c.Yy), Z: float64(c.Zy)}
+		step := geo.Vec3{X: float64(c.VXy), Y: float64(c.VYy), Z: float64(c.VZy)}
+		return StripHit{Proj: ProjY, Strip: bit, Pos: origin.Add(step.Mul(float64(bit)))}, true
+	}
+	return StripHit{}, false
+}
+
+// Hits распаковывает сработавшие стрипы последнего события ДЕКОР.
+// Если событие не содержит данных ДЕКОР, возвращает nil.
+func (d *StrDecor) Hits() ([]StripHit, error) {
+	if d.LenCeventAll == 0 {
+		return nil, nil
+	}
+	return d.CeventAll.Strips(&d.Conf)
+}
+
+// GroupHits распределяет стрипы hits по супермодулям, плоскостям и проекциям.
+func GroupHits(hits []StripHit) *[MAXPM]ModuleHits {
+	var modules [MAXPM]ModuleHits
+	for _, h := range hits {
+		plane := &modules[h.Module][h.Plane]
+		if h.Proj == ProjX {
+			plane.X = append(plane.X, h)
+		} else {
+			plane.Y = append(plane.Y, h)
+		}
+	}
+	return &modules
+}
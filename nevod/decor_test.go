@@ -0,0 +1,170 @@
+package nevod
+
+import (
+	"math"
+	"testing"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+func TestDecorEventStrips(t *testing.T) {
+	var conf [MAXPM * NPLANE]ConfChannel
+	// ПМ 1, контроллер 2, канал 3.
+	lam := 1*NPLANE + 2*NCHAN + 3
+	conf[lam] = ConfChannel{
+		Include: 1,
+		Nx:      320,
+		Ny:      64,
+		Xx:      100, Yx: 0, Zx: 0,
+		VXx: 10,
+		Xy:  0, Yy: 200, Zy: 0,
+		VYy: 10,
+	}
+	var e DecorEvent
+	k := lam / NCHAN
+	e.Meta.MaskCntr = 1 << uint(k)
+	e.Meta.MaskaLamChan[lam/8] = 1 << uint(lam%8)
+	e.Meta.MaskLenMaskMask = 1 << uint(k)
+	data := []uint8{
+		0x21, 0x00, // маска масок: группы 0 и 5
+		0x02, 0x81, // группа 0: байт 1, стрипы 8 и 15
+		0x01, 0x04, // группа 5: байт 0, стрип 322
+	}
+	copy(e.Buf[:], data)
+	e.Meta.LenAllData = int16(len(data))
+
+	hits, err := e.Strips(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StripHit{
+		{Module: 1, Plane: 11, Proj: ProjX, Strip: 8},
+		{Module: 1, Plane: 11, Proj: ProjX, Strip: 15},
+		{Module: 1, Plane: 11, Proj: ProjY, Strip: 2},
+	}
+	if len(hits) != len(expected) {
+		t.Fatalf("hits == %v", hits)
+	}
+	for i := range expected {
+		h := hits[i]
+		h.Pos = expected[i].Pos
+		if h != expected[i] {
+			t.Errorf("hits[%d] == %v", i, hits[i])
+		}
+	}
+	if hits[0].Pos.X != 180 || hits[2].Pos.Y != 220 {
+		t.Errorf("invalid strip positions %v %v", hits[0].Pos, hits[2].Pos)
+	}
+
+	e.Meta.LenAllData = 3
+	if _, err := e.Strips(&conf); err != ErrDecorTruncated {
+		t.Errorf("truncated data error == %v", err)
+	}
+}
+
+// testDecorConf возвращает конфигурацию супермодулей modules по planes плоскостей со стрипами шагом 10 мм.
+func testDecorConf(modules []int, planes int) *[MAXPM * NPLANE]ConfChannel {
+	var conf [MAXPM * NPLANE]ConfChannel
+	for _, m := range modules {
+		for p := 0; p < planes; p++ {
+			z := float32(m*2000 + p*100)
+			conf[m*NPLANE+p] = ConfChannel{
+				Include: 1,
+				Nx:      200,
+				Ny:      200,
+				Zx:      z,
+				VXx:     10,
+				Zy:      z,
+				VYy:     10,
+			}
+		}
+	}
+	return &conf
+}
+
+// testMuonHits возвращает стрипы прямой point+vector*z в плоскостях planes супермодулей modules.
+// Для плоскостей из skipX и skipY стрипы проекций X и Y не срабатывают.
+func testMuonHits(conf *[MAXPM * NPLANE]ConfChannel, modules []int, planes int, point, vector geo.Vec3, skipX, skipY int) []StripHit {
+	var hits []StripHit
+	for _, m := range modules {
+		for p := 0; p < planes; p++ {
+			c := &conf[m*NPLANE+p]
+			pos := point.Add(vector.Mul(float64(c.Zx)))
+			for _, hit := range []StripHit{
+				{Proj: ProjX, Strip: int(math.Floor(pos.X/10 + 0.5))},
+				{Proj: ProjY, Strip: int(math.Floor(pos.Y/10 + 0.5))},
+			} {
+				if hit.Proj == ProjX && p == skipX || hit.Proj == ProjY && p == skipY {
+					continue
+				}
+				bit := hit.Strip
+				if hit.Proj == ProjY {
+					bit += int(c.Nx)
+				}
+				hit, _ = c.strip(bit)
+				hit.Module, hit.Plane = m, p
+				hits = append(hits, hit)
+			}
+		}
+	}
+	return hits
+}
+
+func TestFindTracks(t *testing.T) {
+	modules, planes := []int{0, 1}, 8
+	conf := testDecorConf(modules, planes)
+	vector := geo.Vec3{X: 0.1, Y: -0.2, Z: 1}
+	point := geo.Vec3{X: 500, Y: 1000}
+	hits := testMuonHits(conf, modules, planes, point, vector, -1, -1)
+	// Шумовой стрип.
+	noise, _ := conf[0].strip(10)
+	hits = append(hits, noise)
+
+	tracks := FindTracks(hits, conf)
+	if len(tracks) != 1 {
+		t.Fatalf("tracks == %v", tracks)
+	}
+	track := tracks[0]
+	if track.Type != TrackLong || len(track.Modules) != 2 {
+		t.Errorf("track type == %d, modules == %v", track.Type, track.Modules)
+	}
+	if cos := math.Abs(track.Line.Vector.Dot(vector.Ort())); cos < math.Cos(0.5*math.Pi/180) {
+		t.Errorf("track vector == %v", track.Line.Vector)
+	}
+	if d := track.Line.Point.Sub(point).Cross(vector.Ort()).Len(); d > 10 {
+		t.Errorf("track point == %v, distance == %g", track.Line.Point, d)
+	}
+}
+
+func TestFindTracksPairing(t *testing.T) {
+	modules, planes := []int{0}, 8
+	conf := testDecorConf(modules, planes)
+	v1, p1 := geo.Vec3{X: 0.1, Y: -0.2, Z: 1}, geo.Vec3{X: 500, Y: 1000}
+	v2, p2 := geo.Vec3{X: -0.3, Y: 0.1, Z: 1}, geo.Vec3{X: 1200, Y: 300}
+
+	// Две частицы, пересекающие все плоскости: сочетание проекций неоднозначно.
+	hits := append(testMuonHits(conf, modules, planes, p1, v1, -1, -1),
+		testMuonHits(conf, modules, planes, p2, v2, -1, -1)...)
+	if tracks := FindTracks(hits, conf); len(tracks) != 0 {
+		t.Errorf("ambiguous projections give tracks %v", tracks)
+	}
+
+	// Пропуски в разных плоскостях различают проекции частиц.
+	hits = append(testMuonHits(conf, modules, planes, p1, v1, 2, 2),
+		testMuonHits(conf, modules, planes, p2, v2, 5, 5)...)
+	tracks := FindTracks(hits, conf)
+	if len(tracks) != 2 {
+		t.Fatalf("tracks == %v", tracks)
+	}
+	for _, track := range tracks {
+		var found bool
+		for _, v := range []geo.Vec3{v1, v2} {
+			if math.Abs(track.Line.Vector.Dot(v.Ort())) > math.Cos(0.5*math.Pi/180) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("ghost track %v", track.Line)
+		}
+	}
+}
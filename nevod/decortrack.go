@@ -0,0 +1,278 @@
+package nevod
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+// Типы треков, как в поле Type трека ДЕКОР объединенных данных.
+// FindTracks присваивает всем трекам тип TrackLong: треки ShSh отбирает внешняя программа
+// обработки ДЕКОР (decor_shsh.dat), и ее критерий здесь не воспроизводится.
+const (
+	TrackLong int8 = 0
+	TrackShSh int8 = 1
+)
+
+const (
+	// Минимальное количество плоскостей с хитами на проекции трека.
+	decorMinPlanes = 4
+	// Окно поиска хитов вокруг проекции трека в шагах стрипа.
+	decorWindow = 1.5
+	// Максимальное количество хитов в проекции супермодуля (события-ливни пропускаются).
+	decorMaxHits = 200
+	// Максимальный угол между треками разных супермодулей для их объединения.
+	decorMaxAngle = 2 * math.Pi / 180
+	// Максимальное расстояние в мм между треками разных супермодулей для их объединения.
+	decorMaxDist = 100
+)
+
+// Track содержит трек, восстановленный по стрипам ДЕКОР.
+type Track struct {
+	// Прямая трека в системе координат НЕВОД.
+	Line geo.Line3
+	// Тип трека; FindTracks записывает TrackLong.
+	Type int8
+	// Номера супермодулей, в которых найден трек.
+	Modules []int
+}
+
+// projPoint содержит хит в проекции: координата плоскости w и измеряемая координата u.
+type projPoint struct {
+	plane int
+	w, u  float64
+}
+
+// projTrack содержит прямую u = a + b*w в проекции, среднюю координату w ее хитов
+// и маску плоскостей с хитами.
+type projTrack struct {
+	a, b, w float64
+	planes  uint32
+}
+
+func (t projTrack) at(w float64) float64 {
+	return t.a + t.b*w
+}
+
+// moduleFrame содержит систему координат супермодуля:
+// направления стрипов X и Y проекций и нормаль к плоскостям.
+type moduleFrame struct {
+	ux, uy, n geo.Vec3
+	pitch     [2]float64
+}
+
+func mkModuleFrame(module int, conf *[MAXPM * NPLANE]ConfChannel) (moduleFrame, bool) {
+	for plane := 0; plane < NPLANE; plane++ {
+		c := &conf[module*NPLANE+plane]
+		if c.Include == 0 {
+			continue
+		}
+		vx := geo.Vec3{X: float64(c.VXx), Y: float64(c.VYx), Z: float64(c.VZx)}
+		vy := geo.Vec3{X: float64(c.VXy), Y: float64(c.VYy), Z: float64(c.VZy)}
+		n := vx.Cross(vy).Ort()
+		if n.Len() == 0 {
+			continue
+		}
+		return moduleFrame{
+			ux:    vx.Ort(),
+			uy:    vy.Ort(),
+			n:     n,
+			pitch: [2]float64{vx.Len(), vy.Len()},
+		}, true
+	}
+	return moduleFrame{}, false
+}
+
+// FindTracks восстанавливает треки по стрипам hits с конфигурацией каналов conf.
+// В каждом супермодуле ищутся прямые в проекциях X и Y, которые объединяются в пространственные треки
+// по совпадению плоскостей с хитами (см. pairProjTracks). Треки разных супермодулей,
+// лежащие на одной прямой, объединяются в один трек.
+func FindTracks(hits []StripHit, conf *[MAXPM * NPLANE]ConfChannel) []Track {
+	modules := GroupHits(hits)
+	var tracks []Track
+	for m := range modules {
+		frame, ok := mkModuleFrame(m, conf)
+		if !ok {
+			continue
+		}
+		var xs, ys []projPoint
+		for plane, hits := range modules[m] {
+			for _, h := range hits.X {
+				xs = append(xs, projPoint{plane, h.Pos.Dot(frame.n), h.Pos.Dot(frame.ux)})
+			}
+			for _, h := range hits.Y {
+				ys = append(ys, projPoint{plane, h.Pos.Dot(frame.n), h.Pos.Dot(frame.uy)})
+			}
+		}
+		xTracks := findProjTracks(xs, decorWindow*frame.pitch[0])
+		yTracks := findProjTracks(ys, decorWindow*frame.pitch[1])
+		for _, pair := range pairProjTracks(xTracks, yTracks) {
+			x, y := xTracks[pair[0]], yTracks[pair[1]]
+			w := (x.w + y.w) / 2
+			point := frame.ux.Mul(x.at(w)).Add(frame.uy.Mul(y.at(w))).Add(frame.n.Mul(w))
+			vector := frame.ux.Mul(x.b).Add(frame.uy.Mul(y.b)).Add(frame.n).Ort()
+			if vector.Z > 0 {
+				vector = vector.Mul(-1)
+			}
+			tracks = append(tracks, Track{
+				Line:    geo.Line3{Point: point, Vector: vector},
+				Type:    TrackLong,
+				Modules: []int{m},
+			})
+		}
+	}
+	return joinTracks(tracks)
+}
+
+// pairProjTracks сопоставляет прямые проекций X и Y одного супермодуля.
+// Проекции одной частицы срабатывают в одних и тех же плоскостях, поэтому прямые
+// объединяются, если каждая из них - единственная лучшая пара другой по числу общих плоскостей
+// и общих плоскостей не меньше decorMinPlanes. Неоднозначные пары (например, параллельные
+// мюоны группы, пересекающие все плоскости) отбрасываются: любое их сочетание дало бы ложные треки.
+func pairProjTracks(xs, ys []projTrack) [][2]int {
+	shared := func(i, j int) int {
+		return bits.OnesCount32(xs[i].planes & ys[j].planes)
+	}
+	// best возвращает индекс единственного лучшего партнера или -1.
+	best := func(n int, score func(int) int) int {
+		bestIdx, bestScore, unique := -1, -1, false
+		for k := 0; k < n; k++ {
+			switch s := score(k); {
+			case s > bestScore:
+				bestIdx, bestScore, unique = k, s, true
+			case s == bestScore:
+				unique = false
+			}
+		}
+		if !unique || bestScore < decorMinPlanes {
+			return -1
+		}
+		return bestIdx
+	}
+	var pairs [][2]int
+	for i := range xs {
+		j := best(len(ys), func(j int) int { return shared(i, j) })
+		if j != -1 && best(len(xs), func(k int) int { return shared(k, j) }) == i {
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+	return pairs
+}
+
+// findProjTracks ищет прямые в проекции, проходящие через хиты не менее чем decorMinPlanes плоскостей.
+// Хиты найденной прямой исключаются из поиска следующих.
+func findProjTracks(points []projPoint, window float64) []projTrack {
+	if len(points) > decorMaxHits {
+		return nil
+	}
+	var tracks []projTrack
+	for {
+		best, bestHits := projTrack{}, []int(nil)
+		for i := range points {
+			for j := range points {
+				if points[i].plane >= points[j].plane || points[i].w == points[j].w {
+					continue
+				}
+				b := (points[j].u - points[i].u) / (points[j].w - points[i].w)
+				t := projTrack{a: points[i].u - b*points[i].w, b: b}
+				if hits := t.collect(points, window); len(hits) > len(bestHits) {
+					best, bestHits = t, hits
+				}
+			}
+		}
+		if len(bestHits) < decorMinPlanes {
+			return tracks
+		}
+		best = fitProjTrack(points, bestHits)
+		tracks = append(tracks, best)
+
+		used := make(map[int]bool)
+		for _, i := range bestHits {
+			used[i] = true
+		}
+		var rest []projPoint
+		for i := range points {
+			if !used[i] {
+				rest = append(rest, points[i])
+			}
+		}
+		points = rest
+	}
+}
+
+// collect возвращает индексы ближайших к прямой хитов каждой плоскости, попадающих в окно window.
+func (t projTrack) collect(points []projPoint, window float64) []int {
+	nearest := make(map[int]int)
+	for i, p := range points {
+		d := math.Abs(t.at(p.w) - p.u)
+		if d > window {
+			continue
+		}
+		if j, ok := nearest[p.plane]; !ok || d < math.Abs(t.at(points[j].w)-points[j].u) {
+			nearest[p.plane] = i
+		}
+	}
+	hits := make([]int, 0, len(nearest))
+	for _, i := range nearest {
+		hits = append(hits, i)
+	}
+	sort.Ints(hits)
+	return hits
+}
+
+func fitProjTrack(points []projPoint, hits []int) projTrack {
+	var sumW, sumU, sumWU, sumWW float64
+	var planes uint32
+	for _, i := range hits {
+		p := points[i]
+		planes |= 1 << uint(p.plane)
+		sumW += p.w
+		sumU += p.u
+		sumWU += p.w * p.u
+		sumWW += p.w * p.w
+	}
+	n := float64(len(hits))
+	det := n*sumWW - sumW*sumW
+	if det == 0 {
+		return projTrack{a: sumU / n, w: sumW / n, planes: planes}
+	}
+	b := (n*sumWU - sumW*sumU) / det
+	return projTrack{a: (sumU - b*sumW) / n, b: b, w: sumW / n, planes: planes}
+}
+
+// joinTracks объединяет треки разных супермодулей, лежащие на одной прямой.
+func joinTracks(tracks []Track) []Track {
+	var result []Track
+	used := make([]bool, len(tracks))
+	for i := range tracks {
+		if used[i] {
+			continue
+		}
+		track := tracks[i]
+		for j := i + 1; j < len(tracks); j++ {
+			if used[j] || tracks[j].Modules[0] == track.Modules[0] || !collinear(track.Line, tracks[j].Line) {
+				continue
+			}
+			used[j] = true
+			track.Line = geo.NewLine3(track.Line.Point, tracks[j].Line.Point)
+			track.Line.Vector = track.Line.Vector.Ort()
+			if track.Line.Vector.Z > 0 {
+				track.Line.Vector = track.Line.Vector.Mul(-1)
+			}
+			track.Modules = append(track.Modules, tracks[j].Modules...)
+		}
+		result = append(result, track)
+	}
+	return result
+}
+
+func collinear(l1, l2 geo.Line3) bool {
+	cos := math.Abs(l1.Vector.Ort().Dot(l2.Vector.Ort()))
+	if math.Acos(math.Min(cos, 1)) > decorMaxAngle {
+		return false
+	}
+	d := l2.Point.Sub(l1.Point)
+	return d.Cross(l1.Vector.Ort()).Len() < decorMaxDist
+}
@@ -0,0 +1,39 @@
+package nevod
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidCount возвращается, если количество блоков в событии превышает допустимое.
+	ErrInvalidCount = errors.New("nevod: invalid number of blocks in event")
+	// ErrNoStopMarker возвращается, если запись не завершается маркером "stop".
+	ErrNoStopMarker = errors.New("nevod: record stop marker not found")
+	// ErrRecordTooLong возвращается, если длина данных записи мониторинга или конфигурации превышает допустимую.
+	ErrRecordTooLong = errors.New("nevod: record data length is too large")
+)
+
+// DataError описывает ошибку чтения данных НЕВОД и место, где она произошла.
+// Исходная ошибка доступна через errors.Is и errors.As.
+type DataError struct {
+	// Смещение записи от начала потока
+	Offset int64
+	// Тип записи
+	Type RecordType
+	// Номер последнего успешно прочитанного события; -1, если событий не было
+	Nevent int64
+	Err    error
+}
+
+func (e *DataError) Error() string {
+	msg := fmt.Sprintf("offset %d: %v record", e.Offset, e.Type)
+	if e.Nevent >= 0 {
+		msg += fmt.Sprintf(" after event %d", e.Nevent)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *DataError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,205 @@
+package nevod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// RecordType тип записи файла NAD.
+type RecordType uint8
+
+const (
+	// RecDecor конфигурация, мониторинг или шумы ДЕКОР.
+	RecDecor RecordType = hdr
+	// RecConfig конфигурация НЕВОД.
+	RecConfig RecordType = confNvd
+	// RecPedestals мониторинг пьедесталов БЭК.
+	RecPedestals RecordType = monpds
+	// RecPedestalsL мониторинг пьедесталов БЭК (monpdsl).
+	RecPedestalsL RecordType = monpdsl
+	// RecAmplitudes мониторинг амплитуд.
+	RecAmplitudes RecordType = monAmpl
+	// RecNoise мониторинг шумов ФЭУ, температур и напряжений БЭК.
+	RecNoise RecordType = monShumTv
+	// RecBek мониторинг шумов триггеров БЭК.
+	RecBek RecordType = monBek
+	// RecEvent событие.
+	RecEvent RecordType = recordEvent
+	// RecPedestalsSct мониторинг пьедесталов БЭП.
+	RecPedestalsSct RecordType = monpdsSct
+	// RecNoiseSct мониторинг шумов ФЭУ, температур и напряжений БЭП.
+	RecNoiseSct RecordType = monShumTvSct
+	// RecBep мониторинг шумов триггеров БЭП.
+	RecBep RecordType = monBep
+)
+
+var recordTypeNames = map[RecordType]string{
+	RecDecor:        "decor",
+	RecConfig:       "confNvd",
+	RecPedestals:    "monpds",
+	RecPedestalsL:   "monpdsl",
+	RecAmplitudes:   "monAmpl",
+	RecNoise:        "monShumTv",
+	RecBek:          "monBek",
+	RecEvent:        "event",
+	RecPedestalsSct: "monpdsSct",
+	RecNoiseSct:     "monShumTvSct",
+	RecBep:          "monBep",
+}
+
+func (t RecordType) String() string {
+	if name, ok := recordTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Record содержит запись файла NAD.
+type Record struct {
+	Type RecordType
+	// Дата и время записи (UTC)
+	Date DateTime
+	// Данные записи: *Event, *StrDecor, *ConfigDat, *MonitDat, *MonitShumTV, *MonitBek,
+	// *MonitSct, *MonitShumTvSct, *MonitBep, []byte для записей, которые не удалось декодировать,
+	// или nil для записей неизвестного формата.
+	Value interface{}
+}
+
+// MonitShumTV данные мониторинга шумов ФЭУ, температур и напряжений БЭК.
+type MonitShumTV struct {
+	MaskBek uint32             //битовая Маска присутствующих в данных БЭК
+	Nbek    int16              //Количество присутствующих в данных БЭК
+	ShumTV  [MAXBEK]SMonShumTV //Результаты мониторинга БЭК
+}
+
+// MonitBek данные мониторинга шумов триггеров БЭК.
+type MonitBek struct {
+	MaskBek uint32          //битовая Маска присутствующих в данных БЭК
+	Nbek    int16           //Количество присутствующих в данных БЭК
+	Bek     [MAXBEK]SMonBek //Результаты мониторинга БЭК
+}
+
+// MonitSct данные мониторинга пьедесталов БЭП.
+type MonitSct struct {
+	MaskBep uint32             //битовая Маска присутствующих в данных БЭП
+	Nbep    int16              //Количество присутствующих в данных БЭП
+	MonPds  [MAXBEP]SMonAdcSct //Результаты мониторинга пьедесталов БЭП
+}
+
+// MonitShumTvSct данные мониторинга шумов ФЭУ, температур и напряжений БЭП.
+type MonitShumTvSct struct {
+	MaskBep uint32                //битовая Маска присутствующих в данных БЭП
+	Nbep    int16                 //Количество присутствующих в данных БЭП
+	ShumTV  [MAXBEP]SMonShumTvSct //Результаты мониторинга БЭП
+}
+
+// MonitBep данные мониторинга шумов триггеров БЭП.
+type MonitBep struct {
+	MaskBep uint32          //битовая Маска присутствующих в данных БЭП
+	Nbep    int16           //Количество присутствующих в данных БЭП
+	Bep     [MAXBEP]SMonBep //Результаты мониторинга БЭП
+}
+
+// ErrMonitTruncated возвращается при недостаточной длине записи мониторинга.
+var ErrMonitTruncated = errors.New("nevod: monitoring record is truncated")
+
+// unmarshalMonit декодирует запись мониторинга data: маску mask присутствующих блоков,
+// их количество n и массив структур блоков entries (указатель на массив).
+//
+// Если запись содержит весь массив, его элементы соответствуют номерам блоков.
+// Иначе записано n структур в порядке установленных битов маски.
+func unmarshalMonit(data []byte, mask *uint32, n *int16, entries interface{}) error {
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, mask); err != nil {
+		return ErrMonitTruncated
+	}
+	if err := binary.Read(r, binary.LittleEndian, n); err != nil {
+		return ErrMonitTruncated
+	}
+	if r.Len() >= binary.Size(entries) {
+		return binary.Read(r, binary.LittleEndian, entries)
+	}
+	array := reflect.ValueOf(entries).Elem()
+	count := int(*n)
+	for _, i := range maskBits(*mask) {
+		if count == 0 || i >= array.Len() {
+			break
+		}
+		if err := binary.Read(r, binary.LittleEndian, array.Index(i).Addr().Interface()); err != nil {
+			return ErrMonitTruncated
+		}
+		count--
+	}
+	return nil
+}
+
+// maxRecordLen максимальная длина данных записи мониторинга или конфигурации.
+// Самая длинная из них (MonitDat) занимает около 16 КиБ.
+const maxRecordLen = 1 << 20
+
+// decodedRecord сообщает, декодирует ли unmarshalRecord записи типа t.
+func decodedRecord(t RecordType) bool {
+	switch t {
+	case RecConfig, RecPedestals, RecPedestalsL, RecNoise, RecBek, RecPedestalsSct, RecNoiseSct, RecBep:
+		return true
+	}
+	return false
+}
+
+// unmarshalRecord декодирует данные data записи типа t.
+// Записи неизвестного формата, а также записи, которые не удалось декодировать, возвращаются как []byte.
+func unmarshalRecord(t RecordType, data []byte) interface{} {
+	var (
+		value interface{}
+		err   error
+	)
+	switch t {
+	case RecConfig:
+		conf := new(ConfigDat)
+		value, err = conf, binary.Read(bytes.NewReader(data), binary.LittleEndian, conf)
+	case RecPedestals, RecPedestalsL:
+		m := new(MonitDat)
+		value, err = m, unmarshalMonit(data, &m.MaskBek, &m.Nbek, &m.MonPds)
+	case RecNoise:
+		m := new(MonitShumTV)
+		value, err = m, unmarshalMonit(data, &m.MaskBek, &m.Nbek, &m.ShumTV)
+	case RecBek:
+		m := new(MonitBek)
+		value, err = m, unmarshalMonit(data, &m.MaskBek, &m.Nbek, &m.Bek)
+	case RecPedestalsSct:
+		m := new(MonitSct)
+		value, err = m, unmarshalMonit(data, &m.MaskBep, &m.Nbep, &m.MonPds)
+	case RecNoiseSct:
+		m := new(MonitShumTvSct)
+		value, err = m, unmarshalMonit(data, &m.MaskBep, &m.Nbep, &m.ShumTV)
+	case RecBep:
+		m := new(MonitBep)
+		value, err = m, unmarshalMonit(data, &m.MaskBep, &m.Nbep, &m.Bep)
+	default:
+		return data
+	}
+	if err != nil {
+		return data
+	}
+	return value
+}
+
+// maskBits возвращает номера установленных битов mask в порядке возрастания.
+func maskBits(mask uint32) []int {
+	var bits []int
+	for i := 0; i < 32; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			bits = append(bits, i)
+		}
+	}
+	return bits
+}
+
+// Time возвращает дату и время d.
+func (d DateTime) Time() time.Time {
+	return time.Date(int(d.Year), time.Month(d.Month), int(d.Day),
+		int(d.Hour), int(d.Minute), int(d.Second), int(d.Hsecond)*10000000, time.UTC)
+}
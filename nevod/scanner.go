@@ -11,13 +11,19 @@ type Scanner struct {
 	nevodData Event
 	header    RecordHeader
 	decorData StrDecor
+	pedestals Pedestals
+	record    Record
 
-	err error
+	// Смещение текущей записи и номер последнего прочитанного события
+	offset int64
+	last   int64
+	err    error
 }
 
 func NewScanner(r io.ReadSeeker) *Scanner {
 	return &Scanner{
 		reader: r,
+		last:   -1,
 	}
 }
 
@@ -25,110 +31,180 @@ func (s *Scanner) Record() *Event {
 	return &s.nevodData
 }
 
+// Decor возвращает данные ДЕКОР: конфигурацию и последнее прочитанное событие.
+func (s *Scanner) Decor() *StrDecor {
+	return &s.decorData
+}
+
+// Pedestals возвращает пьедесталы БЭК из последних прочитанных записей мониторинга.
+func (s *Scanner) Pedestals() *Pedestals {
+	return &s.pedestals
+}
+
+// Error возвращает ошибку чтения *DataError или nil, если данные прочитаны до конца.
 func (s *Scanner) Error() error {
 	return s.err
 }
 
-func (s *Scanner) Scan() (success bool) {
-	for {
-		if err := binary.Read(s.reader, binary.LittleEndian, &s.header); err != nil {
+// Scan читает записи до следующего события.
+// Событие доступно через Record, данные ДЕКОР - через Decor.
+func (s *Scanner) Scan() bool {
+	for s.ScanAny() {
+		if s.record.Type == RecEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// Any возвращает последнюю прочитанную ScanAny запись.
+func (s *Scanner) Any() *Record {
+	return &s.record
+}
+
+// ScanAny читает следующую запись любого типа, которая становится доступна через Any.
+// Для событий Value совпадает с Record(), для записей ДЕКОР - с Decor().
+// Записи мониторинга пьедесталов БЭК также обновляют Pedestals.
+func (s *Scanner) ScanAny() (success bool) {
+	offset, err := s.reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	s.offset = offset
+	if err := binary.Read(s.reader, binary.LittleEndian, &s.header); err != nil {
+		if err != io.EOF {
 			s.setError(err)
-			return
 		}
+		return
+	}
+	s.record = Record{Type: RecordType(s.header.RecType), Date: s.header.Date}
 
-		switch s.header.RecType {
-		case hdr:
-			{
-				recordType := s.header.DataLen
-				switch recordType {
-				case idConfig:
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfCntr); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Conf); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.TrigConf); err != nil {
-						s.setError(err)
-						return
-					}
-				case idMonit:
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfMonit); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.CmonitAll); err != nil {
-						s.setError(err)
-						return
-					}
-				case idNoise:
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Counter); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfNoise); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.IDcnoise); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.LenCnoise); err != nil {
-						s.setError(err)
-						return
-					}
-					if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Cnoise); err != nil {
-						s.setError(err)
-						return
-					}
+	switch s.header.RecType {
+	case hdr:
+		{
+			recordType := s.header.DataLen
+			switch recordType {
+			case idConfig:
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfCntr); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Conf); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.TrigConf); err != nil {
+					s.setError(err)
+					return
+				}
+			case idMonit:
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfMonit); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.CmonitAll); err != nil {
+					s.setError(err)
+					return
+				}
+			case idNoise:
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Counter); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfNoise); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.IDcnoise); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.LenCnoise); err != nil {
+					s.setError(err)
+					return
+				}
+				if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.Cnoise); err != nil {
+					s.setError(err)
+					return
 				}
 			}
-		case recordEvent:
-			if err := s.nevodData.Unmarshal(s.reader); err != nil {
+			s.record.Value = &s.decorData
+		}
+	case recordEvent:
+		if err := s.nevodData.Unmarshal(s.reader); err != nil {
+			s.setError(err)
+			return
+		}
+		var lenadd [2]uint8
+		if _, err := io.ReadFull(s.reader, lenadd[:]); err != nil {
+			s.setError(err)
+			return
+		}
+		if lenadd[0] != 0 {
+			if _, err := s.reader.Seek(int64(4*lenadd[0]), 1); err != nil {
+				s.setError(err)
+				return
+			}
+		}
+		s.decorData.LenCeventAll = 0
+		if lenadd[1] != 0 {
+			if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfEvent); err != nil {
+				s.setError(err)
+				return
+			}
+			if err := binary.Read(s.reader, binary.LittleEndian, &s.decorData.LenCeventAll); err != nil {
 				s.setError(err)
 				return
 			}
-			var lenadd [2]uint8
-			s.reader.Read(lenadd[:])
-			if lenadd[0] != 0 {
-				if _, err := s.reader.Seek(int64(4*lenadd[0]), 1); err != nil {
+			if s.decorData.LenCeventAll != 0 {
+				if err := s.decorData.CeventAll.Unmarshal(s.reader); err != nil {
 					s.setError(err)
 					return
 				}
 			}
-			if lenadd[1] != 0 {
-				binary.Read(s.reader, binary.LittleEndian, &s.decorData.ConfEvent)
-				binary.Read(s.reader, binary.LittleEndian, &s.decorData.LenCeventAll)
-				if s.decorData.LenCeventAll != 0 {
-					if err := s.decorData.CeventAll.Unmarshal(s.reader); err != nil {
-						s.setError(err)
-						return
-					}
-				}
-			}
-			success = true
-		default:
-			if _, err := s.reader.Seek(int64(s.header.DataLen), 1); err != nil {
+		}
+		s.record.Value = &s.nevodData
+		s.last = int64(s.nevodData.Meta.Nevent)
+	default:
+		if !decodedRecord(s.record.Type) {
+			// Данные записей неизвестного формата пропускаются, не загружаясь в память.
+			if _, err := s.reader.Seek(int64(s.header.DataLen), io.SeekCurrent); err != nil {
 				s.setError(err)
 				return
 			}
+			s.record.Value = nil
+			break
 		}
-		var bstop [4]uint8
-		if _, err := s.reader.Read(bstop[:]); err != nil || string(bstop[:]) != "stop" {
-			s.setError(err)
+		if s.header.DataLen > maxRecordLen {
+			s.setError(ErrRecordTooLong)
 			return
 		}
-		if success {
+		data := make([]byte, s.header.DataLen)
+		if _, err := io.ReadFull(s.reader, data); err != nil {
+			s.setError(err)
 			return
 		}
+		s.record.Value = unmarshalRecord(s.record.Type, data)
+		if m, ok := s.record.Value.(*MonitDat); ok && s.record.Type == RecPedestals {
+			s.pedestals.Update(m)
+		}
+	}
+	var bstop [4]uint8
+	if _, err := io.ReadFull(s.reader, bstop[:]); err != nil {
+		s.setError(err)
+		return
+	} else if string(bstop[:]) != "stop" {
+		s.setError(ErrNoStopMarker)
+		return
 	}
+	return true
 }
 
+// setError сохраняет ошибку чтения текущей записи. Конец данных внутри записи означает ее обрыв.
 func (s *Scanner) setError(err error) {
-	if err != io.EOF {
-		s.err = err
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
+	s.err = &DataError{Offset: s.offset, Type: RecordType(s.header.RecType), Nevent: s.last, Err: err}
 }
@@ -0,0 +1,82 @@
+package nevod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestScannerScanAny(t *testing.T) {
+	var buf bytes.Buffer
+	writeRecord := func(t RecordType, data []byte) {
+		h := RecordHeader{RecType: uint8(t), DataLen: uint32(len(data))}
+		copy(h.Start[:], "start")
+		binary.Write(&buf, binary.LittleEndian, &h)
+		buf.Write(data)
+		buf.WriteString("stop")
+	}
+	var monit bytes.Buffer
+	binary.Write(&monit, binary.LittleEndian, uint32(1<<2))
+	binary.Write(&monit, binary.LittleEndian, int16(1))
+	var ped SMonADC
+	ped.Sred[0][0][Dinode12] = 42
+	binary.Write(&monit, binary.LittleEndian, &ped)
+	writeRecord(RecPedestals, monit.Bytes())
+	writeRecord(RecAmplitudes, []byte{1, 2, 3})
+
+	var event bytes.Buffer
+	e := Event{Meta: EventMeta{Nevent: 7, Nrun: 1}}
+	e.Marshal(&event)
+	event.Write([]byte{0, 0})
+	writeRecord(RecEvent, event.Bytes())
+
+	s := NewScanner(bytes.NewReader(buf.Bytes()))
+	var types []RecordType
+	for s.ScanAny() {
+		types = append(types, s.Any().Type)
+		switch v := s.Any().Value.(type) {
+		case *MonitDat:
+			if v.MonPds[2].Sred[0][0][Dinode12] != 42 {
+				t.Errorf("monpds == %v", v.MonPds[2])
+			}
+		case nil:
+			if s.Any().Type != RecAmplitudes {
+				t.Errorf("%v record is not decoded", s.Any().Type)
+			}
+		case *Event:
+			if v.Meta.Nevent != 7 {
+				t.Errorf("event == %v", v.Meta)
+			}
+		}
+	}
+	if err := s.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || types[0] != RecPedestals || types[1] != RecAmplitudes || types[2] != RecEvent {
+		t.Errorf("record types == %v", types)
+	}
+	if !s.Pedestals().Valid[2] {
+		t.Error("pedestals are not updated")
+	}
+
+	s = NewScanner(bytes.NewReader(buf.Bytes()))
+	if !s.Scan() || s.Record().Meta.Nevent != 7 {
+		t.Error("Scan failed to skip monitoring records")
+	}
+}
+
+func TestScannerRecordTooLong(t *testing.T) {
+	var buf bytes.Buffer
+	h := RecordHeader{RecType: uint8(RecNoise), DataLen: 1 << 31}
+	copy(h.Start[:], "start")
+	binary.Write(&buf, binary.LittleEndian, &h)
+
+	s := NewScanner(bytes.NewReader(buf.Bytes()))
+	if s.ScanAny() {
+		t.Fatal("record with corrupt length is scanned")
+	}
+	if err := s.Error(); !errors.Is(err, ErrRecordTooLong) {
+		t.Errorf("error == %v", err)
+	}
+}
@@ -125,11 +125,11 @@ type StrDecor struct {
 	IDcinit        [MAXPM]int16 // индикатор наличия данных
 	LenCinit       [MAXPM]int16 // длина данных
 	Cinit          [MAXPM]ConfPm
-	Nrun           int32            // Номер запуска программы
-	ConfCntr       uint32           // маска контроллеров по конфигурации
-	Conf           [128]ConfChannel // Структура конфигурации всего ДЕКОРа
-	TrigConf       [2]TrigConf      //Конфигурация для триггерной платы
-	ConfMonit      uint32           // маска контроллеров по мониторингу
+	Nrun           int32                       // Номер запуска программы
+	ConfCntr       uint32                      // маска контроллеров по конфигурации
+	Conf           [MAXPM * NPLANE]ConfChannel // Структура конфигурации всего ДЕКОРа
+	TrigConf       [2]TrigConf                 //Конфигурация для триггерной платы
+	ConfMonit      uint32                      // маска контроллеров по мониторингу
 	CmonitAll      CMonitorAll
 	Counter        [2][8]uint16 //шумы триггеров за 1 сек
 	ConfNoise      uint32       // маска контроллеров по шумам
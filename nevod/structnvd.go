@@ -140,7 +140,7 @@ type ConfigDat struct {
 
 // MonitDat TODO
 type MonitDat struct {
-	MaskBek uint        //битовая Маска присутствующих в данных БЭК или БЭП
+	MaskBek uint32      //битовая Маска присутствующих в данных БЭК или БЭП
 	Nbek    int16       //Количество присутствующих в данных БЭК или БЭП
 	MonPds  [32]SMonADC //Результаты мониторинга пьедесталов БЭК
 }
@@ -180,6 +180,9 @@ func (e *Event) Unmarshal(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &e.Meta); err != nil {
 		return err
 	}
+	if e.Meta.Nbek < 0 || int(e.Meta.Nbek) > len(e.EventBek) || e.Meta.Nbep < 0 || int(e.Meta.Nbep) > len(e.EventBep) {
+		return ErrInvalidCount
+	}
 	if err := binary.Read(r, binary.LittleEndian, e.EventBek[:e.Meta.Nbek]); err != nil {
 		return err
 	}
@@ -0,0 +1,247 @@
+// Package parquet реализует запись таблиц в формате Apache Parquet.
+// Поддерживаются только обязательные колонки простых типов,
+// кодировка PLAIN и запись без сжатия.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type тип значений колонки.
+type Type int32
+
+const (
+	// Int32 32-битное целое.
+	Int32 Type = 1
+	// Int64 64-битное целое.
+	Int64 Type = 2
+	// Double число двойной точности.
+	Double Type = 5
+)
+
+// Column содержит описание колонки.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// DefaultGroupRows количество строк в группе по умолчанию.
+const DefaultGroupRows = 1 << 16
+
+const (
+	magic         = "PAR1"
+	pageData      = 0
+	encodingPlain = 0
+	encodingRLE   = 3
+	codecNone     = 0
+)
+
+type chunkMeta struct {
+	offset int64
+	size   int64
+}
+
+type rowGroup struct {
+	chunks []chunkMeta
+	rows   int64
+	size   int64
+}
+
+// Writer осуществляет запись таблицы в формате Parquet.
+// Строки накапливаются в памяти и записываются группами по GroupRows строк.
+type Writer struct {
+	// Количество строк в группе.
+	GroupRows int
+
+	w       io.Writer
+	offset  int64
+	columns []Column
+	buffers []bytes.Buffer
+	rows    int64
+	groups  []rowGroup
+}
+
+// NewWriter создает Writer, записывающий таблицу с колонками columns в w.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	pw := &Writer{
+		GroupRows: DefaultGroupRows,
+		w:         w,
+		columns:   columns,
+		buffers:   make([]bytes.Buffer, len(columns)),
+	}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// WriteRow добавляет строку values. Количество и типы значений должны соответствовать колонкам.
+func (w *Writer) WriteRow(values ...interface{}) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("parquet: invalid values count %d", len(values))
+	}
+	var b [8]byte
+	for i, v := range values {
+		switch w.columns[i].Type {
+		case Int32:
+			x, ok := toInt64(v)
+			if !ok {
+				return fmt.Errorf("parquet: invalid value %v of column %s", v, w.columns[i].Name)
+			}
+			binary.LittleEndian.PutUint32(b[:], uint32(int32(x)))
+			w.buffers[i].Write(b[:4])
+		case Int64:
+			x, ok := toInt64(v)
+			if !ok {
+				return fmt.Errorf("parquet: invalid value %v of column %s", v, w.columns[i].Name)
+			}
+			binary.LittleEndian.PutUint64(b[:], uint64(x))
+			w.buffers[i].Write(b[:])
+		case Double:
+			x, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("parquet: invalid value %v of column %s", v, w.columns[i].Name)
+			}
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+			w.buffers[i].Write(b[:])
+		}
+	}
+	w.rows++
+	if w.rows >= int64(w.GroupRows) {
+		return w.Flush()
+	}
+	return nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+// Flush записывает накопленные строки в виде группы.
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	group := rowGroup{rows: w.rows}
+	for i := range w.buffers {
+		data := w.buffers[i].Bytes()
+		var t thriftWriter
+		t.beginStruct()
+		t.fieldI32(1, pageData)
+		t.fieldI32(2, int32(len(data)))
+		t.fieldI32(3, int32(len(data)))
+		t.fieldStruct(5)
+		t.fieldI32(1, int32(w.rows))
+		t.fieldI32(2, encodingPlain)
+		t.fieldI32(3, encodingRLE)
+		t.fieldI32(4, encodingRLE)
+		t.endStruct()
+		t.endStruct()
+
+		chunk := chunkMeta{
+			offset: w.offset,
+			size:   int64(t.buf.Len() + len(data)),
+		}
+		if err := w.write(t.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := w.write(data); err != nil {
+			return err
+		}
+		w.buffers[i].Reset()
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.size
+	}
+	w.groups = append(w.groups, group)
+	w.rows = 0
+	return nil
+}
+
+// Close записывает оставшиеся строки и метаданные файла. Close не закрывает нижележащий io.Writer.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	var t thriftWriter
+	t.beginStruct()
+	t.fieldI32(1, 1)
+	t.fieldList(2, thriftStruct, len(w.columns)+1)
+	t.beginStruct()
+	t.fieldString(4, "schema")
+	t.fieldI32(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, c := range w.columns {
+		t.beginStruct()
+		t.fieldI32(1, int32(c.Type))
+		t.fieldI32(3, 0)
+		t.fieldString(4, c.Name)
+		t.endStruct()
+	}
+	var rows int64
+	for _, g := range w.groups {
+		rows += g.rows
+	}
+	t.fieldI64(3, rows)
+	t.fieldList(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.beginStruct()
+		t.fieldList(1, thriftStruct, len(g.chunks))
+		for i, c := range g.chunks {
+			t.beginStruct()
+			t.fieldI64(2, c.offset)
+			t.fieldStruct(3)
+			t.fieldI32(1, int32(w.columns[i].Type))
+			t.fieldList(2, thriftI32, 2)
+			t.i32(encodingPlain)
+			t.i32(encodingRLE)
+			t.fieldList(3, thriftBinary, 1)
+			t.string(w.columns[i].Name)
+			t.fieldI32(4, codecNone)
+			t.fieldI64(5, g.rows)
+			t.fieldI64(6, c.size)
+			t.fieldI64(7, c.size)
+			t.fieldI64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.fieldI64(2, g.size)
+		t.fieldI64(3, g.rows)
+		t.endStruct()
+	}
+	t.fieldString(6, "CtudcHandler")
+	t.endStruct()
+
+	if err := w.write(t.buf.Bytes()); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(t.buf.Len()))
+	if err := w.write(size[:]); err != nil {
+		return err
+	}
+	return w.write([]byte(magic))
+}
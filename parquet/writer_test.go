@@ -0,0 +1,38 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{{"run", Int64}, {"chamber", Int32}, {"dev", Double}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.GroupRows = 2
+	for i := 0; i < 5; i++ {
+		if err := w.WriteRow(uint(i), i, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteRow(1, 2); err == nil {
+		t.Error("WriteRow with invalid values count succeeded")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatal("invalid magic")
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("invalid footer size %d", footer)
+	}
+	if len(w.groups) != 3 || w.groups[2].rows != 1 {
+		t.Errorf("invalid row groups %v", w.groups)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	path "path/filepath"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Максимальное количество хитов правдоподобного события.
+	repairMaxHits = 4096
+	// Максимальный прирост номера события между соседними правдоподобными событиями.
+	repairMaxEventGap = 100000
+	// Размер заголовка записи события: номер рана, номер события, время и количество хитов.
+	recordHeaderSize = 8 + 8 + 8 + 4
+	// Размер записи хита.
+	recordHitSize = 4 + 4
+)
+
+// skippedRange содержит диапазон байт файла, отброшенный при восстановлении.
+type skippedRange struct {
+	start, end int64
+}
+
+// Состояния записи события при восстановлении.
+const (
+	// Запись не похожа на событие
+	recordBad = iota
+	// Правдоподобное событие
+	recordOK
+	// Событие с недопустимым номером проволоки; структура записи не нарушена
+	recordInvalidWire
+	// Начало правдоподобного события, оборванного концом данных
+	recordTruncated
+)
+
+// checkRecord проверяет, что с начала data записано структурно правдоподобное событие рана run,
+// и возвращает состояние и размер записи.
+func checkRecord(data []byte, run int) (int, int) {
+	if len(data) < recordHeaderSize {
+		return recordBad, 0
+	}
+	if binary.LittleEndian.Uint64(data) != uint64(run) {
+		return recordBad, 0
+	}
+	nhits := binary.LittleEndian.Uint32(data[24:])
+	if nhits > repairMaxHits {
+		return recordBad, 0
+	}
+	size := recordHeaderSize + int(nhits)*recordHitSize
+	if len(data) < size {
+		return recordTruncated, len(data)
+	}
+	var e trek.Event
+	switch err := e.Unmarshal(bytes.NewReader(data[:size])); err {
+	case nil:
+		return recordOK, size
+	case trek.ErrInvalidWire:
+		return recordInvalidWire, size
+	}
+	return recordBad, 0
+}
+
+// monotonic сообщает, что номер события записи с начала data следует за номером prev.
+// Нарушение порядка допустимо, например при сбросе счетчика, поэтому используется лишь как признак.
+func monotonic(data []byte, prev uint64, first bool) bool {
+	nevent := binary.LittleEndian.Uint64(data[8:])
+	return first || (nevent > prev && nevent-prev <= repairMaxEventGap)
+}
+
+// confirmed проверяет, что событие размером size с позиции pos data продолжается
+// depth структурно правдоподобными записями или концом данных.
+func confirmed(data []byte, pos, size, run, depth int) bool {
+	next := pos + size
+	for i := 0; i < depth; i++ {
+		if len(data)-next < recordHeaderSize {
+			return true
+		}
+		status, n := checkRecord(data[next:], run)
+		switch status {
+		case recordBad:
+			return false
+		case recordTruncated:
+			return true
+		}
+		next += n
+	}
+	return true
+}
+
+// acceptRecord проверяет запись с позиции pos data и возвращает ее состояние, размер
+// и признак того, что запись подтверждена следующими записями.
+// Запись с нарушенным порядком номеров подтверждается двумя записями вместо одной.
+func acceptRecord(data []byte, pos, run int, prev uint64, first bool) (int, int, bool) {
+	status, size := checkRecord(data[pos:], run)
+	if status != recordOK && status != recordInvalidWire {
+		return status, size, false
+	}
+	depth := 1
+	if !monotonic(data[pos:], prev, first) {
+		depth = 2
+	}
+	return status, size, confirmed(data, pos, size, run, depth)
+}
+
+// resync ищет в data начиная с позиции pos следующее подтвержденное правдоподобное событие.
+// Возвращает позицию события или len(data), если события не найдено.
+func resync(data []byte, pos, run int, prev uint64, first bool) int {
+	for ; pos < len(data); pos++ {
+		if _, _, ok := acceptRecord(data, pos, run, prev, first); ok {
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// repairData возвращает события рана run из data, пропуская поврежденные участки.
+// offset - смещение data от начала файла.
+func repairData(data []byte, offset int64, run int) ([]byte, []skippedRange) {
+	var (
+		clean   []byte
+		skipped []skippedRange
+		prev    uint64
+		first   = true
+	)
+	skip := func(start, end int) {
+		skipped = append(skipped, skippedRange{offset + int64(start), offset + int64(end)})
+	}
+	for pos := 0; pos < len(data); {
+		status, size, ok := acceptRecord(data, pos, run, prev, first)
+		switch {
+		case status == recordTruncated:
+			skip(pos, len(data))
+			pos = len(data)
+		case !ok && status == recordBad:
+			next := resync(data, pos+1, run, prev, first)
+			skip(pos, next)
+			pos = next
+		case !ok:
+			// Структурно правдоподобная запись, за которой следует мусор. Если внутри нее
+			// начинается подтвержденное событие, запись оборвана; иначе она сохраняется.
+			if next := resync(data, pos+1, run, prev, first); next < pos+size {
+				skip(pos, next)
+				pos = next
+				break
+			}
+			fallthrough
+		default:
+			if status == recordOK {
+				clean = append(clean, data[pos:pos+size]...)
+			} else {
+				skip(pos, pos+size)
+			}
+			prev, first = binary.LittleEndian.Uint64(data[pos+8:]), false
+			pos += size
+		}
+	}
+	return clean, skipped
+}
+
+// backupName возвращает имя для сохранения исходного файла filename,
+// не совпадающее с существующими файлами: filename.bad, filename.bad.1 и т.д.
+func backupName(filename string) (string, error) {
+	name := filename + ".bad"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s.bad.%d", filename, i)
+	}
+}
+
+// repairFile восстанавливает файл КТУДК filename рана run.
+// Если файл поврежден, он сохраняется под именем backupName и заменяется очищенной копией.
+func repairFile(filename string, run int) ([]skippedRange, error) {
+	f, err := openData(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		header string
+		body   = data
+	)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		if h, err := trek.ParseHeader(string(data[:i])); err == nil && h.Format != trek.FormatExt {
+			header, body = string(data[:i+1]), data[i+1:]
+		}
+	}
+	if header == "" {
+		// Поврежденный заголовок отбрасывается вместе с данными до первого события.
+		header = trek.NewFileHeader(trek.FormatCtudc, producer(), run, 0).String() + "\n"
+	}
+	clean, skipped := repairData(body, int64(len(data)-len(body)), run)
+	if len(skipped) == 0 {
+		return nil, nil
+	}
+	w, err := createData(splitCompressedExt(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Abort()
+	if w.Compressed() {
+		header = trek.AddHeaderFlags(header, trek.FlagCompressed)
+	}
+	if _, err := w.WriteString(header); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(clean); err != nil {
+		return nil, err
+	}
+	backup, err := backupName(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceWithBackup(w, filename, backup); err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// replaceWithBackup заменяет файл filename данными w, сохраняя исходный файл под именем backup.
+// Резервная копия создается жесткой ссылкой до замены, поэтому при ошибке записи
+// исходный файл остается под своим именем, а резервная копия удаляется.
+func replaceWithBackup(w *dataWriter, filename, backup string) error {
+	if err := os.Link(filename, backup); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(backup)
+		return err
+	}
+	return nil
+}
+
+// repairRun восстанавливает файлы КТУДК рана run и записывает журнал отброшенных участков.
+func repairRun(run int) error {
+	set, err := readFileSet(formatCtudcSubdir(run), ".tds")
+	if err != nil {
+		return err
+	}
+	logname := path.Join(formatCtudcSubdir(run), fmt.Sprintf("repair_%05d.log", run))
+	var (
+		lines   []string
+		damaged int
+	)
+	for _, filename := range set.Paths() {
+		skipped, err := repairFile(filename, run)
+		if err != nil {
+			return fmt.Errorf("Failed repair %s: %s", filename, err)
+		}
+		if len(skipped) != 0 {
+			damaged++
+			log.Printf("Repaired %s: %d damaged ranges\n", filename, len(skipped))
+		}
+		for _, s := range skipped {
+			lines = append(lines, fmt.Sprintf("%s\t%d\t%d\t%d", path.Base(filename), s.start, s.end, s.end-s.start))
+		}
+	}
+	if damaged == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(logname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# %s\t%s\t%s\t%s\n", "file", "start", "end", "bytes")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// repair восстанавливает поврежденные файлы КТУДК ранов runs.
+// Поврежденные участки пропускаются до следующего правдоподобного события;
+// исходные файлы сохраняются с расширением .bad, существующие копии не перезаписываются.
+func repair(runs []int) error {
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Repairing ", formatCtudcSubdir(run))
+		return nil, repairRun(run)
+	}, func(*runResult) error {
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// testRecord возвращает запись события nevent рана testRun с хитами на сырых номерах проволок wires.
+func testRecord(nevent int, wires ...uint32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint64{testRun, uint64(nevent), uint64(1000 * nevent)})
+	binary.Write(&buf, binary.LittleEndian, uint32(len(wires)))
+	for i, wire := range wires {
+		binary.Write(&buf, binary.LittleEndian, []uint32{wire, uint32(100 + i)})
+	}
+	return buf.Bytes()
+}
+
+func testRecords(nevents ...int) []byte {
+	var data []byte
+	for _, n := range nevents {
+		data = append(data, testRecord(n, 0, 1, 2, 3)...)
+	}
+	return data
+}
+
+func checkRepair(t *testing.T, data, clean []byte, skipped []skippedRange) {
+	t.Helper()
+	gotClean, gotSkipped := repairData(data, 0, testRun)
+	if !bytes.Equal(gotClean, clean) {
+		t.Errorf("clean data has %d bytes, expected %d", len(gotClean), len(clean))
+	}
+	if !reflect.DeepEqual(gotSkipped, skipped) {
+		t.Errorf("skipped %v, expected %v", gotSkipped, skipped)
+	}
+}
+
+func TestRepairClean(t *testing.T) {
+	data := testRecords(seq(1, 10)...)
+	checkRepair(t, data, data, nil)
+}
+
+func TestRepairCounterRestart(t *testing.T) {
+	data := append(testRecords(seq(1, 20)...), testRecords(seq(1, 20)...)...)
+	checkRepair(t, data, data, nil)
+}
+
+func TestRepairTruncated(t *testing.T) {
+	clean := testRecords(1, 2, 3)
+	data := append(append([]byte(nil), clean...), testRecords(4)[:20]...)
+	checkRepair(t, data, clean, []skippedRange{{int64(len(clean)), int64(len(data))}})
+}
+
+func TestRepairTruncatedInside(t *testing.T) {
+	head, tail := testRecords(1, 2), testRecords(4, 5, 6)
+	cut := testRecords(3)[:40]
+	data := append(append(append([]byte(nil), head...), cut...), tail...)
+	checkRepair(t, data, append(append([]byte(nil), head...), tail...),
+		[]skippedRange{{int64(len(head)), int64(len(head) + len(cut))}})
+}
+
+func TestRepairGarbage(t *testing.T) {
+	head, tail := testRecords(1, 2, 3), testRecords(4, 5, 6)
+	garbage := bytes.Repeat([]byte{0xAB}, 37)
+	data := append(append(append([]byte(nil), head...), garbage...), tail...)
+	checkRepair(t, data, append(append([]byte(nil), head...), tail...),
+		[]skippedRange{{int64(len(head)), int64(len(head) + len(garbage))}})
+}
+
+func TestRepairInvalidWire(t *testing.T) {
+	// Сырой номер проволоки 7 выходит за пределы 0..3.
+	first, bad, last := testRecords(1), testRecord(2, 0, 7), testRecords(3)
+	data := append(append(append([]byte(nil), first...), bad...), last...)
+	checkRepair(t, data, append(append([]byte(nil), first...), last...),
+		[]skippedRange{{int64(len(first)), int64(len(first) + len(bad))}})
+}
+
+// writeRepairFile записывает файл КТУДК рана testRun и возвращает его имя.
+func writeRepairFile(t *testing.T, header string, data []byte) string {
+	appConf.CtudcRoot = t.TempDir()
+	dir := formatCtudcSubdir(testRun)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	filename := formatCtudcFilename(testRun, 0)
+	if err := ioutil.WriteFile(filename, append([]byte(header), data...), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func testHeader() string {
+	return trek.NewFileHeader(trek.FormatCtudc, producer(), testRun, 0).String() + "\n"
+}
+
+func repairLog() string {
+	return filepath.Join(formatCtudcSubdir(testRun), "repair_00007.log")
+}
+
+func TestRepairRunClean(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	data := testRecords(seq(1, 10)...)
+	filename := writeRepairFile(t, testHeader(), data)
+	if err := repairRun(testRun); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".bad"); !os.IsNotExist(err) {
+		t.Errorf("backup of clean file: %v", err)
+	}
+	if _, err := os.Stat(repairLog()); !os.IsNotExist(err) {
+		t.Errorf("log of clean run: %v", err)
+	}
+}
+
+func TestRepairRunDamagedHeader(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	data := testRecords(seq(1, 10)...)
+	header := "TDSh format=garbage\n"
+	filename := writeRepairFile(t, header, data)
+	if err := repairRun(testRun); err != nil {
+		t.Fatal(err)
+	}
+	repaired, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.IndexByte(repaired, '\n')
+	if h, err := trek.ParseHeader(string(repaired[:i])); err != nil || h.Run != testRun {
+		t.Errorf("repaired header %q: %v", repaired[:i], err)
+	}
+	if !bytes.Equal(repaired[i+1:], data) {
+		t.Error("repaired data differ")
+	}
+	if _, err := os.Stat(filename + ".bad"); err != nil {
+		t.Errorf("backup: %v", err)
+	}
+	if _, err := os.Stat(repairLog()); err != nil {
+		t.Errorf("log: %v", err)
+	}
+}
+
+func TestRepairRunKeepsBackup(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	data := append(testRecords(1, 2, 3), 0xAB, 0xAB)
+	filename := writeRepairFile(t, testHeader(), data)
+	if err := ioutil.WriteFile(filename+".bad", []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repairRun(testRun); err != nil {
+		t.Fatal(err)
+	}
+	if old, err := ioutil.ReadFile(filename + ".bad"); err != nil || string(old) != "old" {
+		t.Errorf("existing backup overwritten: %q, %v", old, err)
+	}
+	if _, err := os.Stat(filename + ".bad.1"); err != nil {
+		t.Errorf("new backup: %v", err)
+	}
+}
+
+func TestReplaceWithBackupFailed(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	header, data := testHeader(), testRecords(seq(1, 10)...)
+	filename := writeRepairFile(t, header, data)
+	w, err := createData(filename, "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(header)
+	// Без временного файла Close не может переименовать его в filename.
+	if err := os.Remove(w.f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceWithBackup(w, filename, filename+".bad"); err == nil {
+		t.Fatal("replace succeeded")
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, append([]byte(header), data...)) {
+		t.Error("original file is changed")
+	}
+	if _, err := os.Stat(filename + ".bad"); !os.IsNotExist(err) {
+		t.Errorf("backup is kept: %v", err)
+	}
+}
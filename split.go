@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bufio"
-	"io/ioutil"
 	"log"
 	"os"
 	path "path/filepath"
@@ -11,8 +9,7 @@ import (
 )
 
 type RunData struct {
-	file       *os.File
-	writer     *bufio.Writer
+	writer     *dataWriter
 	eventCount int
 	fileCount  int
 	lastRecord uint
@@ -25,17 +22,22 @@ func pathExists(name string) bool {
 }
 
 func (r *RunData) Close() error {
-	if err := r.writer.Flush(); err != nil {
-		return err
-	}
-	if err := r.file.Close(); err != nil {
-		return err
+	return r.writer.Close()
+}
+
+// writeCtudcHeader записывает в w заголовок файла событий КТУДК рана run.
+func writeCtudcHeader(w *dataWriter, run int) {
+	var flags trek.HeaderFlags
+	if w.Compressed() {
+		flags |= trek.FlagCompressed
 	}
-	return nil
+	w.WriteString(trek.NewFileHeader(trek.FormatCtudc, producer(), run, flags).String() + "\n")
 }
 
 func split(patterns []string) error {
-	header := "TDSa\n"
+	if err := checkCompression(*compression); err != nil {
+		return err
+	}
 	runWriters := map[int]*RunData{}
 
 	defer func() {
@@ -55,12 +57,13 @@ func split(patterns []string) error {
 		s, err := trek.NewScanner(f)
 		if err != nil {
 			return err
-		} else if s.Header() == "TDSdrop" {
+		} else if s.FileHeader().Format == trek.FormatDrop {
 			log.Printf("Skipping drop\n")
 			return nil
 		}
 
-		for s.Scan() {
+		s.SetName(path.Base(filename))
+		for scanValid(s) {
 			record := s.Record()
 			run := int(record.Nrun())
 			runWriter := runWriters[run]
@@ -83,32 +86,28 @@ func split(patterns []string) error {
 				if err := os.MkdirAll(ctudcdir, 0777); err != nil {
 					return err
 				}
-				filename := formatCtudcFilename(run, 0)
-
-				f, err := os.Create(filename)
+				w, err := createData(formatCtudcFilename(run, 0), *compression)
 				if err != nil {
 					return err
 				}
-				log.Println("Created: ", filename)
-				w := bufio.NewWriter(f)
-				w.WriteString(header)
+				log.Println("Created: ", w.Name())
+				writeCtudcHeader(w, run)
 				runWriter = &RunData{
-					file:   f,
 					writer: w,
 				}
 				runWriters[run] = runWriter
 			} else if runWriter.eventCount > 10000 {
+				if err := runWriter.Close(); err != nil {
+					return err
+				}
 				runWriter.fileCount++
 				runWriter.eventCount = 0
-				filename := formatCtudcFilename(run, runWriter.fileCount)
-				f, err := os.Create(filename)
+				w, err := createData(formatCtudcFilename(run, runWriter.fileCount), *compression)
 				if err != nil {
 					return err
 				}
-				log.Println("Created: ", filename)
-				w := bufio.NewWriter(f)
-				w.WriteString(header)
-				runWriter.file = f
+				log.Println("Created: ", w.Name())
+				writeCtudcHeader(w, run)
 				runWriter.writer = w
 				runWriters[run] = runWriter
 			}
@@ -118,6 +117,9 @@ func split(patterns []string) error {
 			runWriter.eventCount++
 			runWriter.lastRecord = record.Nevent()
 		}
+		if err := s.Err(); err != nil {
+			log.Println("Failed read ctudc data:", err)
+		}
 		return nil
 	}
 
@@ -129,15 +131,13 @@ func split(patterns []string) error {
 		}
 		for _, dirname := range dirnames {
 			log.Println("Processing: ", dirname)
-			files, err := ioutil.ReadDir(dirname)
+			set, err := readFileSet(dirname, ".tds")
 			if err != nil {
 				return err
 			}
-			for _, filestat := range files {
-				if path.Ext(filestat.Name()) != ".tds" {
-					continue
-				}
-				if err := splitFile(path.Join(dirname, filestat.Name())); err != nil {
+			set.logGaps()
+			for _, filename := range set.Paths() {
+				if err := splitFile(filename); err != nil {
 					return err
 				}
 			}
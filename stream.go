@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// streamState содержит состояние горутины, читающей данные в канал.
+type streamState struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func newStreamState(ctx context.Context) (context.Context, *streamState) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &streamState{cancel: cancel, done: make(chan struct{})}
+}
+
+// finish сохраняет ошибку чтения err. Вызывается горутиной до закрытия канала данных.
+func (s *streamState) finish(err error) {
+	s.err = err
+	close(s.done)
+}
+
+// Err ожидает завершения чтения и возвращает его ошибку.
+// Если чтение прервано Close или отменой контекста, возвращает ошибку контекста.
+func (s *streamState) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close прекращает чтение и ожидает завершения горутины.
+func (s *streamState) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// ctudcStream передает события КТУДК через канал C.
+// После закрытия C ошибка чтения доступна через Err.
+type ctudcStream struct {
+	C <-chan trek.Event
+	*streamState
+}
+
+// nevodStream передает события НЕВОД через канал C.
+// После закрытия C ошибка чтения доступна через Err.
+type nevodStream struct {
+	C <-chan nevodRecord
+	*streamState
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Количество бинов спектра времен одной проволки.
+	t0Bins = 400
+	// Минимальное количество измерений для фита спектра.
+	t0MinEntries = 500
+	// Допустимое отклонение T0 проволки от медианы по камере в долях времени дрейфа.
+	t0ShiftTolerance = 0.05
+)
+
+// t0Fit содержит результат фита спектра времен одной проволки.
+type t0Fit struct {
+	entries float64
+	t0      float64
+	rise    float64
+	tmax    float64
+	fall    float64
+	chi2ndf float64
+	ok      bool
+}
+
+// t0Model описывает спектр времен дрейфа: фон и плато, ограниченное
+// функциями Ферми на переднем (T0) и заднем (Tmax) фронтах.
+func t0Model(t float64, p []float64) float64 {
+	rise := 1 + math.Exp((p[2]-t)/math.Abs(p[3]))
+	fall := 1 + math.Exp((t-p[4])/math.Abs(p[5]))
+	return p[0] + p[1]/(rise*fall)
+}
+
+func fitSpectrum(h *histogram) t0Fit {
+	fit := t0Fit{entries: h.entries()}
+	if fit.entries < t0MinEntries {
+		return fit
+	}
+	peak := 0.0
+	for _, n := range h.bins {
+		peak = math.Max(peak, n)
+	}
+	first, last := -1, -1
+	for i, n := range h.bins {
+		if n > peak/2 && first == -1 {
+			first = i
+		}
+		if n > peak/4 {
+			last = i
+		}
+	}
+	var bg, plateau float64
+	for _, n := range h.bins[:first/2] {
+		bg += n / float64(first/2)
+	}
+	for _, n := range h.bins[first : last+1] {
+		plateau += n / float64(last+1-first)
+	}
+
+	chi2 := func(p []float64) float64 {
+		var sum float64
+		for i, n := range h.bins {
+			d := n - t0Model(h.center(i), p)
+			sum += d * d / math.Max(n, 1)
+		}
+		return sum
+	}
+	p0 := []float64{bg, plateau - bg, h.center(first), 2 * h.width, h.center(last), 4 * h.width}
+	step := []float64{math.Max(bg, 1), plateau / 10, 2 * h.width, h.width, 4 * h.width, 2 * h.width}
+	p, val := geo.Minimize(chi2, p0, step, 20000, 1e-10)
+
+	fit.t0, fit.rise = p[2], math.Abs(p[3])
+	fit.tmax, fit.fall = p[4], math.Abs(p[5])
+	fit.chi2ndf = val / float64(len(h.bins)-len(p))
+	fit.ok = p[1] > 0 && fit.t0 > h.min && fit.t0 < fit.tmax && fit.tmax < h.center(len(h.bins)-1)
+	return fit
+}
+
+// t0 извлекает T0 и Tmax каждой проволки из спектров времен ранов runs
+// и записывает обновленный chambers.conf.new и отчет о качестве фитов.
+func t0(runs []int) error {
+	if err := os.MkdirAll(*outDir, 0777); err != nil {
+		return fmt.Errorf("Failed create output dir: %s", err)
+	}
+	config, ok, failed, err := readRunsChamberConfig(runs, readRawChamberConfig)
+	if err != nil {
+		return err
+	}
+	spectra := make(map[int]*[4]*histogram)
+	initSpectra(config, spectra)
+	for _, run := range ok {
+		log.Println("Processing ", run)
+		if err := fillSpectra(run, spectra); err != nil {
+			log.Println("Failed:", err)
+			failed = append(failed, run)
+		} else {
+			log.Println("Success")
+		}
+	}
+
+	report, err := os.Create(outputPath("t0_report.dat"))
+	if err != nil {
+		return fmt.Errorf("Failed create report file: %s", err)
+	}
+	defer report.Close()
+	fmt.Fprintf(report, "# %8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%s\n",
+		"chamber", "wire", "entries", "t0", "rise", "tmax", "fall", "chi2/ndf", "status")
+	for i := range config {
+		desc := &config[i]
+		hists, ok := spectra[desc.Number-1]
+		if !ok {
+			continue
+		}
+		var fits [4]t0Fit
+		for wire, h := range hists {
+			fits[wire] = fitSpectrum(h)
+		}
+		median := medianT0(fits[:])
+		rmax := trek.NewChamber(*desc).Width() / 2
+		for wire, fit := range fits {
+			status := "ok"
+			switch {
+			case fit.entries < t0MinEntries:
+				status = "dead"
+			case !fit.ok:
+				status = "nofit"
+			case math.Abs(fit.t0-median) > t0ShiftTolerance*(fit.tmax-fit.t0):
+				status = "shifted"
+			}
+			fmt.Fprintf(report, "%10d\t%8d\t%8.0f\t%8.1f\t%8.1f\t%8.1f\t%8.1f\t%8.3f\t%s\n",
+				desc.Number, wire+1, fit.entries, fit.t0, fit.rise, fit.tmax, fit.fall, fit.chi2ndf, status)
+			if fit.ok {
+				desc.Offsets[wire] = uint(math.Max(fit.t0, 0) + 0.5)
+				desc.Speeds[wire] = rmax / (fit.tmax - fit.t0)
+			}
+		}
+	}
+	if err := writeRawChamberConfig(outputPath("chambers.conf.new"), config); err != nil {
+		return fmt.Errorf("Failed write chamber config: %s", err)
+	}
+	return failedRunsError(failed, len(runs))
+}
+
+// initSpectra создает гистограммы времен для каждой проволки камер из config.
+// Диапазон гистограмм выбирается по текущим оффсетам и скоростям дрейфа.
+func initSpectra(config []trek.ChamberDesc, spectra map[int]*[4]*histogram) {
+	for _, desc := range config {
+		rmax := trek.NewChamber(desc).Width() / 2
+		hists := new([4]*histogram)
+		for wire := range hists {
+			drift := 1e6
+			if desc.Speeds[wire] > 0 {
+				drift = rmax / desc.Speeds[wire]
+			}
+			hists[wire] = newHistogram(0, float64(desc.Offsets[wire])+2*drift, t0Bins)
+		}
+		spectra[desc.Number-1] = hists
+	}
+}
+
+func fillSpectra(run int, spectra map[int]*[4]*histogram) error {
+	events, err := ctudcReader(context.Background(), formatCtudcSubdir(run))
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	for event := range events.C {
+		for cham, times := range event.Times() {
+			hists, ok := spectra[cham]
+			if !ok {
+				continue
+			}
+			for wire := range times {
+				for _, t := range times[wire] {
+					hists[wire].fill(float64(t))
+				}
+			}
+		}
+	}
+	return events.Err()
+}
+
+func medianT0(fits []t0Fit) float64 {
+	var t0s []float64
+	for _, fit := range fits {
+		if fit.ok {
+			t0s = append(t0s, fit.t0)
+		}
+	}
+	if len(t0s) == 0 {
+		return 0
+	}
+	sort.Float64s(t0s)
+	return t0s[len(t0s)/2]
+}
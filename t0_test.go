@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+func TestFitSpectrum(t *testing.T) {
+	const (
+		t0, rise   = 180.0, 6.0
+		tmax, fall = 820.0, 15.0
+	)
+	rnd := rand.New(rand.NewSource(1))
+	h := newHistogram(0, 1200, t0Bins)
+	for i := range h.bins {
+		mean := t0Model(h.center(i), []float64{5, 100, t0, rise, tmax, fall})
+		// Шум с дисперсией пуассоновского распределения.
+		h.bins[i] = math.Max(math.Round(mean+math.Sqrt(mean)*rnd.NormFloat64()), 0)
+	}
+	fit := fitSpectrum(h)
+	if !fit.ok {
+		t.Fatalf("fit failed: %+v", fit)
+	}
+	if math.Abs(fit.t0-t0) > 2 || math.Abs(fit.tmax-tmax) > 4 {
+		t.Errorf("t0 %.1f, tmax %.1f; expected %.1f, %.1f", fit.t0, fit.tmax, t0, tmax)
+	}
+	if math.Abs(fit.rise-rise) > 2 || math.Abs(fit.fall-fall) > 5 {
+		t.Errorf("rise %.1f, fall %.1f; expected %.1f, %.1f", fit.rise, fit.fall, rise, fall)
+	}
+}
+
+func TestFitSpectrumFewEntries(t *testing.T) {
+	h := newHistogram(0, 1200, t0Bins)
+	h.bins[100] = t0MinEntries - 1
+	if fitSpectrum(h).ok {
+		t.Error("fit of spectrum with too few entries")
+	}
+}
+
+func TestReadRunsChamberConfig(t *testing.T) {
+	defer func(conf appConfig) { appConf = conf }(appConf)
+	appConf.CtudcRoot = t.TempDir()
+	config := []trek.ChamberDesc{{Number: 1, Offsets: [4]uint{100, 100, 100, 100}}}
+	for _, run := range []int{1, 2, 3} {
+		if err := os.MkdirAll(formatRunDir(run), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if run == 3 {
+			config[0].Offsets[0] = 120
+		}
+		if err := writeRawChamberConfig(formatChamberConfig(run), config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, failed, err := readRunsChamberConfig([]int{1, 2, 4}, readRawChamberConfig); err != nil ||
+		len(ok) != 2 || len(failed) != 1 || failed[0] != 4 {
+		t.Errorf("runs %v, failed %v: %v", ok, failed, err)
+	}
+	if _, _, _, err := readRunsChamberConfig([]int{1, 2, 3}, readRawChamberConfig); err == nil {
+		t.Error("different chamber configs accepted")
+	}
+}
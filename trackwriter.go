@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/frostoov/CtudcHandler/parquet"
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+// trackRecord содержит трек камеры и его сравнение с проекцией трека ДЕКОР.
+type trackRecord struct {
+	run     uint
+	event   uint
+	chamber int
+	times   trek.TrackTimes
+	k1, k2  int
+	dev     float64
+	cAng    float64
+	cB      float64
+	dAng    float64
+	dB      float64
+}
+
+// trackWriter осуществляет вывод треков.
+type trackWriter interface {
+	Write(r *trackRecord) error
+	Close() error
+}
+
+// newTrackWriter создает trackWriter для формата format: text|parquet.
+func newTrackWriter(format string) (trackWriter, error) {
+	switch format {
+	case "text":
+		return &textTrackWriter{
+			files:   make(map[int]*os.File),
+			writers: make(map[int]*bufio.Writer),
+		}, nil
+	case "parquet":
+		return newParquetTrackWriter(outputPath("tracks.parquet"))
+	default:
+		return nil, fmt.Errorf("Invalid track format %q", format)
+	}
+}
+
+func formatTracksHeader() string {
+	var buf bytes.Buffer
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&buf, "WIRE_%03d\t", i+1)
+	}
+	fmt.Fprintf(&buf, "%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s",
+		"k1", "k2", "dev", "ang[C]", "b[C]", "ang[D]", "b[D]", "dang", "db")
+	return buf.String()
+}
+
+// textTrackWriter записывает треки каждой камеры в отдельный текстовый файл tracks/chamber_NNN.dat директории результатов.
+type textTrackWriter struct {
+	files   map[int]*os.File
+	writers map[int]*bufio.Writer
+}
+
+func (t *textTrackWriter) Write(r *trackRecord) error {
+	w := t.writers[r.chamber]
+	if w == nil {
+		f, err := os.Create(outputPath("tracks", fmt.Sprintf("chamber_%03d.dat", r.chamber+1)))
+		if err != nil {
+			return fmt.Errorf("Failed create track file: %s", err)
+		}
+		w = bufio.NewWriter(f)
+		if _, err := fmt.Fprintln(w, "#", formatTracksHeader()); err != nil {
+			f.Close()
+			return fmt.Errorf("Failed write track header: %s", err)
+		}
+		t.files[r.chamber] = f
+		t.writers[r.chamber] = w
+	}
+	fmt.Fprintf(w, "%8d\t%8d\t%8d\t%8d\t", r.times[0], r.times[1], r.times[2], r.times[3])
+	fmt.Fprintf(w, "%8d\t%8d\t", r.k1, r.k2)
+	fmt.Fprintf(w, "%8f\t%8f\t%8f\t", r.dev, r.cAng, r.cB)
+	fmt.Fprintf(w, "%8f\t%8f\t", r.dAng, r.dB)
+	_, err := fmt.Fprintf(w, "%8f\t%8f\n", r.cAng-r.dAng, r.cB-r.dB)
+	return err
+}
+
+func (t *textTrackWriter) Close() error {
+	var err error
+	for cham, f := range t.files {
+		if e := t.writers[cham].Flush(); e != nil && err == nil {
+			err = e
+		}
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// trackColumns колонки файла треков в формате Parquet.
+var trackColumns = []parquet.Column{
+	{Name: "run", Type: parquet.Int64},
+	{Name: "event", Type: parquet.Int64},
+	{Name: "chamber", Type: parquet.Int32},
+	{Name: "t1", Type: parquet.Int64},
+	{Name: "t2", Type: parquet.Int64},
+	{Name: "t3", Type: parquet.Int64},
+	{Name: "t4", Type: parquet.Int64},
+	{Name: "k1", Type: parquet.Int64},
+	{Name: "k2", Type: parquet.Int64},
+	{Name: "dev", Type: parquet.Double},
+	{Name: "ang_c", Type: parquet.Double},
+	{Name: "b_c", Type: parquet.Double},
+	{Name: "ang_d", Type: parquet.Double},
+	{Name: "b_d", Type: parquet.Double},
+	{Name: "dang", Type: parquet.Double},
+	{Name: "db", Type: parquet.Double},
+}
+
+// parquetTrackWriter записывает треки всех камер в один файл формата Parquet.
+type parquetTrackWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	table  *parquet.Writer
+}
+
+func newParquetTrackWriter(filename string) (*parquetTrackWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create track file: %s", err)
+	}
+	w := bufio.NewWriter(f)
+	table, err := parquet.NewWriter(w, trackColumns)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &parquetTrackWriter{
+		file:   f,
+		writer: w,
+		table:  table,
+	}, nil
+}
+
+func (p *parquetTrackWriter) Write(r *trackRecord) error {
+	return p.table.WriteRow(r.run, r.event, r.chamber+1,
+		r.times[0], r.times[1], r.times[2], r.times[3], r.k1, r.k2,
+		r.dev, r.cAng, r.cB, r.dAng, r.dB, r.cAng-r.dAng, r.cB-r.dB)
+}
+
+func (p *parquetTrackWriter) Close() error {
+	if err := p.table.Close(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if err := p.writer.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/frostoov/CtudcHandler/trek"
+	"github.com/xitongsys/parquet-go-source/local"
+	pqreader "github.com/xitongsys/parquet-go/reader"
+)
+
+// TestParquetTrackWriter проверяет, что файл треков читается независимой реализацией Parquet.
+func TestParquetTrackWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tracks.parquet")
+
+	records := []trackRecord{
+		{run: 1200, event: 7, chamber: 0, times: trek.TrackTimes{10, 20, 30, 40}, k1: 1, k2: 2,
+			dev: 0.25, cAng: 1.5, cB: 100, dAng: 1.25, dB: 90},
+		{run: 1200, event: 9, chamber: 15, times: trek.TrackTimes{110, 220, 330, 440}, k1: -1, k2: 3,
+			dev: 1.75, cAng: -0.5, cB: -12.5, dAng: -0.75, dB: -10},
+		{run: 1201, event: 1 << 40, chamber: 3, times: trek.TrackTimes{5, 6, 7, 8}, k1: 0, k2: 0,
+			dev: 0, cAng: 0.125, cB: 3, dAng: 0.5, dB: 4},
+	}
+	w, err := newParquetTrackWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Несколько групп строк, последняя неполная
+	w.table.GroupRows = 2
+	for i := range records {
+		if err := w.Write(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := local.NewLocalFileReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pr, err := pqreader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if n := pr.GetNumRows(); n != int64(len(records)) {
+		t.Fatalf("rows == %d", n)
+	}
+	schema := pr.SchemaHandler.SchemaElements
+	if len(schema) != len(trackColumns)+1 {
+		t.Fatalf("schema has %d elements", len(schema))
+	}
+	for i, c := range trackColumns {
+		// Имена элементов схемы приводятся читателем к виду полей Go, исходные хранятся в Infos
+		e, name := schema[i+1], pr.SchemaHandler.Infos[i+1].ExName
+		if name != c.Name || e.Type == nil || int32(*e.Type) != int32(c.Type) {
+			t.Errorf("column %d == %s %v, expected %s %d", i, name, e.Type, c.Name, c.Type)
+		}
+	}
+
+	columns := make([][]interface{}, len(trackColumns))
+	for j := range trackColumns {
+		columns[j], _, _, err = pr.ReadColumnByIndex(int64(j), int64(len(records)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(columns[j]) != len(records) {
+			t.Fatalf("column %s has %d values", trackColumns[j].Name, len(columns[j]))
+		}
+	}
+	for i, r := range records {
+		expected := []interface{}{
+			int64(r.run), int64(r.event), int32(r.chamber + 1),
+			int64(r.times[0]), int64(r.times[1]), int64(r.times[2]), int64(r.times[3]), int64(r.k1), int64(r.k2),
+			r.dev, r.cAng, r.cB, r.dAng, r.dB, r.cAng - r.dAng, r.cB - r.dB,
+		}
+		got := make([]interface{}, len(trackColumns))
+		for j := range columns {
+			got[j] = columns[j][i]
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("row %d == %v, expected %v", i, got, expected)
+		}
+	}
+}
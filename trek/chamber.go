@@ -2,6 +2,7 @@ package trek
 
 import (
 	"math"
+	"sort"
 
 	geo "github.com/frostoov/CtudcHandler/math"
 )
@@ -14,6 +15,8 @@ type ChamberDesc struct {
 	Offsets [4]uint `json:"offsets"`
 	//Скорость дрейфа для каждой проволки.
 	Speeds [4]float64 `json:"speeds"`
+	//Таблицы r(t) для каждой проволки. Если заданы, используются вместо Offsets и Speeds.
+	RT *[4]RTable `json:"rt,omitempty"`
 	//Координаты проволок в системе координат камеры
 	Wires [4]geo.Vec2 `json:"wires"`
 	//Номер плоскости дрейфовой камеры.
@@ -42,6 +45,13 @@ const (
 	chamberLength = 4000
 )
 
+const (
+	// Максимальное отклонение трека при поиске нескольких треков в камере.
+	maxTrackDeviation = 10
+	// Максимальное количество комбинаций измерений при поиске нескольких треков в камере.
+	maxCombinations = 4096
+)
+
 // Chamber представляет дрейфовую камеру.
 type Chamber struct {
 	desc  ChamberDesc
@@ -64,6 +74,37 @@ func (c *Chamber) LineProjection(l geo.Line3) geo.Line2 {
 	return geo.NewLine2Vec(geo.Vec2{X: l.Point.X, Y: l.Point.Y}, geo.Vec2{X: l.Vector.X, Y: l.Vector.Y})
 }
 
+// TrackPlane возвращает плоскость в системе координат НЕВОД, содержащую трек track
+// и направление проволок камеры. Для вырожденного трека возвращает geo.ErrNullVector.
+func (c *Chamber) TrackPlane(track *TrackDesc) (geo.Plane, error) {
+	pt, vec, err := track.Line.Vectors()
+	if err != nil {
+		return geo.Plane{}, err
+	}
+	dir := geo.Vec3{X: vec.X, Y: vec.Y, Z: 0}
+	norm := geo.Line3{
+		Point:  geo.Vec3{X: pt.X, Y: pt.Y, Z: 0},
+		Vector: dir.Cross(geo.Vec3{X: 0, Y: 0, Z: 1}),
+	}
+	norm = c.coord.RestoreLine(norm)
+	return geo.NewPlaneNorm(norm.Vector, norm.Point), nil
+}
+
+// CrossingPosition возвращает координату вдоль длины камеры, в которой прямая l
+// пересекает плоскость проволок. Если прямая параллельна плоскости, возвращает false.
+func (c *Chamber) CrossingPosition(l geo.Line3) (float64, bool) {
+	l = c.coord.ConvertLine(l)
+	if l.Vector.X == 0 {
+		return 0, false
+	}
+	var x float64
+	for _, wire := range c.desc.Wires {
+		x += wire.X / float64(len(c.desc.Wires))
+	}
+	t := (x - l.Point.X) / l.Vector.X
+	return l.Point.Z + t*l.Vector.Z, true
+}
+
 // TimesDepth возвращает наименьшую "глубину" измерений для 4 проволок.
 func (c *Chamber) TimesDepth(times *ChamTimes) int {
 	depth := -1
@@ -86,6 +127,13 @@ func (c *Chamber) CreateTrack(times *ChamTimes) *TrackDesc {
 	return c.mkTrackDesc(times)
 }
 
+// CreateTracks реконструирует все треки по измерениям с камеры.
+// Каждое измерение входит не более чем в один трек.
+// Треки упорядочены по возрастанию отклонения.
+func (c *Chamber) CreateTracks(times *ChamTimes) []TrackDesc {
+	return c.mkTracks(times)
+}
+
 // Hexahendron возвращает геометрическое представление камеры.
 func (c *Chamber) Hexahendron() *geo.Hexahedron {
 	return &c.hex
@@ -116,6 +164,36 @@ func (c *Chamber) Speeds() []float64 {
 	return c.desc.Speeds[:]
 }
 
+// RT возвращает таблицы r(t) камеры или nil, если камера использует постоянную скорость дрейфа.
+func (c *Chamber) RT() *[4]RTable {
+	return c.desc.RT
+}
+
+// DriftDist возвращает расстояние дрейфа для измерения t с проволки wire.
+// Если измерение выходит за пределы камеры, возвращает false.
+func (c *Chamber) DriftDist(wire int, t uint) (float64, bool) {
+	if c.desc.RT != nil && c.desc.RT[wire].Valid() {
+		return c.desc.RT[wire].Dist(t)
+	}
+	offset, speed := c.desc.Offsets[wire], c.desc.Speeds[wire]
+	if t <= offset {
+		return 0, false
+	}
+	dist := float64(t-offset) * speed
+	return dist, math.Abs(dist) < chamberWidth/2
+}
+
+// Residuals возвращает невязки трека track для каждой проволки:
+// разность расстояния от прямой трека до проволки и расстояния дрейфа.
+func (c *Chamber) Residuals(track *TrackDesc) [4]float64 {
+	var res [4]float64
+	for i, wire := range c.desc.Wires {
+		dist, _ := c.DriftDist(i, track.Times[i])
+		res[i] = math.Abs(track.Line.K()*wire.X+track.Line.B()-wire.Y) - dist
+	}
+	return res
+}
+
 // Width возвращает ширину дрейфовой камеры.
 func (c *Chamber) Width() float64 {
 	return chamberWidth
@@ -179,7 +257,8 @@ func (c *Chamber) mkTrackDesc(times *ChamTimes) *TrackDesc {
 	if depth != 1 {
 		return nil
 	}
-	dists := mkChamDists(times, &c.desc)
+	times = c.goodTimes(times)
+	dists := c.mkChamDists(times)
 
 	desc := TrackDesc{
 		Deviation: math.Inf(1),
@@ -206,6 +285,70 @@ func (c *Chamber) mkTrackDesc(times *ChamTimes) *TrackDesc {
 	return &desc
 }
 
+func (c *Chamber) mkTracks(times *ChamTimes) []TrackDesc {
+	times = c.goodTimes(times)
+	dists := c.mkChamDists(times)
+	// Произведение проверяется на каждом шаге, чтобы не допустить переполнения на шумных событиях.
+	combinations := 1
+	for wire := range dists {
+		combinations *= len(dists[wire])
+		if combinations == 0 || combinations > maxCombinations {
+			return nil
+		}
+	}
+
+	type candidate struct {
+		desc TrackDesc
+		hits [4]int
+	}
+	var candidates []candidate
+	var p [4]int
+	for p[0] = range dists[0] {
+		for p[1] = range dists[1] {
+			for p[2] = range dists[2] {
+				for p[3] = range dists[3] {
+					var desc TrackDesc
+					trackDists := mkTrackDists(dists, &p)
+					if !c.mkTrack(&trackDists, &desc) {
+						continue
+					}
+					desc.Times = mkTrackTimes(times, &p)
+					if c.systemError(&desc) && desc.Deviation < maxTrackDeviation {
+						candidates = append(candidates, candidate{desc, p})
+					}
+				}
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].desc.Deviation < candidates[j].desc.Deviation
+	})
+
+	//Отбираем лучшие треки, не имеющие общих измерений.
+	var used [4]map[int]bool
+	for wire := range used {
+		used[wire] = make(map[int]bool)
+	}
+	var tracks []TrackDesc
+	for _, cand := range candidates {
+		free := true
+		for wire, hit := range cand.hits {
+			if used[wire][hit] {
+				free = false
+				break
+			}
+		}
+		if !free {
+			continue
+		}
+		for wire, hit := range cand.hits {
+			used[wire][hit] = true
+		}
+		tracks = append(tracks, cand.desc)
+	}
+	return tracks
+}
+
 func (c *Chamber) mkTrack(dists *TrackDists, desc *TrackDesc) bool {
 	points := c.desc.Wires
 	var line geo.Line2
@@ -248,13 +391,25 @@ func mkTrackDists(chamDists *ChamDists, p *[4]int) TrackDists {
 	return dists
 }
 
-func mkChamDists(times *ChamTimes, desc *ChamberDesc) *ChamDists {
+// goodTimes возвращает измерения, попадающие в пределы камеры.
+func (c *Chamber) goodTimes(times *ChamTimes) *ChamTimes {
+	var good ChamTimes
+	for wire := range times {
+		for _, t := range times[wire] {
+			if c.isTimeGood(wire, t) {
+				good[wire] = append(good[wire], t)
+			}
+		}
+	}
+	return &good
+}
+
+func (c *Chamber) mkChamDists(times *ChamTimes) *ChamDists {
 	var dists ChamDists
 	for wire := range times {
 		for _, time := range times[wire] {
-			offset, speed := desc.Offsets[wire], desc.Speeds[wire]
-			if time > offset && math.Abs(float64(time-offset)*speed) < chamberWidth/2 {
-				dists[wire] = append(dists[wire], float64(time-offset)*speed)
+			if dist, ok := c.DriftDist(wire, time); ok {
+				dists[wire] = append(dists[wire], dist)
 			}
 		}
 	}
@@ -336,5 +491,6 @@ func min(a, b int) int {
 }
 
 func (c *Chamber) isTimeGood(wire int, t uint) bool {
-	return t > c.desc.Offsets[wire] && math.Abs(float64(t-c.desc.Offsets[wire])*c.desc.Speeds[wire]) < chamberWidth/2
+	_, ok := c.DriftDist(wire, t)
+	return ok
 }
@@ -0,0 +1,42 @@
+package trek
+
+import (
+	"math"
+	"testing"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+func testChamber() *Chamber {
+	return NewChamber(ChamberDesc{
+		Points: [3]geo.Vec3{{X: 0, Y: 0, Z: 0}, {X: 500, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 4000}},
+		Speeds: [4]float64{0.25, 0.25, 0.25, 0.25},
+		Wires:  DefaultWires,
+	})
+}
+
+func TestChamberCreateTracks(t *testing.T) {
+	c := testChamber()
+	times := ChamTimes{
+		{37, 243},
+		{237, 43},
+		{37, 243},
+		{237, 43},
+	}
+	tracks := c.CreateTracks(&times)
+	if len(tracks) != 2 {
+		t.Fatalf("len(c.CreateTracks()) == %d", len(tracks))
+	}
+	expected := []float64{10, -60}
+	for _, track := range tracks {
+		found := false
+		for _, b := range expected {
+			if math.Abs(track.Line.B()-b) < 1e-6 && math.Abs(track.Line.K()) < 1e-6 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("unexpected track k = %v, b = %v", track.Line.K(), track.Line.B())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package trek
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidWire возвращается при чтении хита с номером проволоки больше 3.
+	ErrInvalidWire = errors.New("hit wire number > 3")
+	// ErrInvalidSize возвращается, если количество элементов записи превышает допустимое.
+	ErrInvalidSize = errors.New("invalid record size")
+)
+
+// DataError описывает ошибку чтения данных и место, где она произошла.
+// Исходная ошибка доступна через errors.Is и errors.As.
+type DataError struct {
+	// Имя файла, если известно
+	File string
+	// Смещение записи от начала распакованного потока
+	Offset int64
+	// Номер последнего успешно прочитанного события; -1, если событий не было
+	Nevent int64
+	Err    error
+}
+
+func (e *DataError) Error() string {
+	msg := fmt.Sprintf("offset %d", e.Offset)
+	if e.File != "" {
+		msg = e.File + ": " + msg
+	}
+	if e.Nevent >= 0 {
+		msg += fmt.Sprintf(" after event %d", e.Nevent)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *DataError) Unwrap() error {
+	return e.Err
+}
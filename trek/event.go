@@ -31,6 +31,9 @@ func (e *Event) Copy() Event {
 func (e *Event) ChamberDepths() map[int]*[4]int {
 	ds := make(map[int]*[4]int)
 	for _, h := range e.hits {
+		if h.Type() != Leading {
+			continue
+		}
 		if ds[h.Chamber()] == nil {
 			ds[h.Chamber()] = new([4]int)
 		}
@@ -43,7 +46,7 @@ func (e *Event) ChamberDepths() map[int]*[4]int {
 func (e *Event) WireDepths(cham int) [4]int {
 	ds := [4]int{}
 	for _, h := range e.hits {
-		if cham == int(h.Chamber()) {
+		if cham == int(h.Chamber()) && h.Type() == Leading {
 			ds[h.Wire()]++
 		}
 	}
@@ -74,10 +77,13 @@ func (e *Event) Hits() []Hit {
 	return e.hits
 }
 
-// Times возвращает измерения со всей установки в формате [chamber]*ChamTimes.
+// Times возвращает времена leading фронтов со всей установки в формате [chamber]*ChamTimes.
 func (e *Event) Times() map[int]*ChamTimes {
 	times := make(map[int]*ChamTimes)
 	for _, h := range e.hits {
+		if h.Type() != Leading {
+			continue
+		}
 		if times[h.Chamber()] == nil {
 			times[h.Chamber()] = new(ChamTimes)
 		}
@@ -86,17 +92,51 @@ func (e *Event) Times() map[int]*ChamTimes {
 	return times
 }
 
-// ChamberTimes возвращает измерения с камеры cham.
+// ChamberTimes возвращает времена leading фронтов с камеры cham.
 func (e *Event) ChamberTimes(cham int) *ChamTimes {
 	var times ChamTimes
 	for _, h := range e.hits {
-		if h.Chamber() == cham {
+		if h.Chamber() == cham && h.Type() == Leading {
 			times[h.Wire()] = append(times[h.Wire()], h.Time())
 		}
 	}
 	return &times
 }
 
+// Pulses возвращает импульсы со всей установки в формате [chamber]*ChamPulses.
+func (e *Event) Pulses() map[int]*ChamPulses {
+	hits := make(map[int]*[4][]Hit)
+	for _, h := range e.hits {
+		if hits[h.Chamber()] == nil {
+			hits[h.Chamber()] = new([4][]Hit)
+		}
+		hits[h.Chamber()][h.Wire()] = append(hits[h.Chamber()][h.Wire()], h)
+	}
+	pulses := make(map[int]*ChamPulses)
+	for cham, wires := range hits {
+		pulses[cham] = new(ChamPulses)
+		for wire := range wires {
+			pulses[cham][wire] = mkPulses(wires[wire])
+		}
+	}
+	return pulses
+}
+
+// ChamberPulses возвращает импульсы с камеры cham.
+func (e *Event) ChamberPulses(cham int) *ChamPulses {
+	var hits [4][]Hit
+	for _, h := range e.hits {
+		if h.Chamber() == cham {
+			hits[h.Wire()] = append(hits[h.Wire()], h)
+		}
+	}
+	var pulses ChamPulses
+	for wire := range hits {
+		pulses[wire] = mkPulses(hits[wire])
+	}
+	return &pulses
+}
+
 // TriggeredChambers возвращает множество всех сработавших камер.
 func (e *Event) TriggeredChambers() map[int]bool {
 	trigChams := make(map[int]bool)
@@ -128,11 +168,26 @@ func (e *Event) Marshal(w io.Writer) error {
 	return nil
 }
 
+// Максимальное количество хитов события; большее значение означает поврежденные данные.
+const maxEventHits = 1 << 20
+
 //Unmarshal осуществляет бинарынй анмаршалинг данных события в r.
+// Если запись оборвана, возвращает io.ErrUnexpectedEOF.
+// При ErrInvalidWire событие считывается полностью, поэтому чтение можно продолжить.
 func (e *Event) Unmarshal(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &e.nRun); err != nil {
 		return err
 	}
+	if err := e.unmarshalBody(r); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *Event) unmarshalBody(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &e.nEvent); err != nil {
 		return err
 	}
@@ -145,17 +200,23 @@ func (e *Event) Unmarshal(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
 		return err
 	}
+	if size > maxEventHits {
+		return ErrInvalidSize
+	}
 	if int(size) < cap(e.hits) {
 		e.hits = e.hits[:size]
 	} else {
 		e.hits = make([]Hit, int(size))
 	}
+	var hitErr error
 	for i := range e.hits {
-		if err := e.hits[i].Unmarshal(r); err != nil {
+		if err := e.hits[i].Unmarshal(r); err == ErrInvalidWire {
+			hitErr = err
+		} else if err != nil {
 			return err
 		}
 	}
-	return nil
+	return hitErr
 }
 
 func marshalTime(w io.Writer, t time.Time) error {
@@ -2,6 +2,7 @@ package trek
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	"time"
 
@@ -96,17 +97,48 @@ type ExtEvent struct {
 	Nevod nevod.EventMeta
 	// Треки ДЕКОР
 	Decor []DecorTrack
+	// Полные данные НЕВОД, если присутствуют в файле
+	Full *NevodData
+}
+
+// NevodData содержит полные данные события НЕВОД.
+type NevodData struct {
+	// Событие НЕВОД
+	Event nevod.Event
+	// Энерговыделение в кодах АЦП 12 и 9 динодов за вычетом пьедесталов
+	Deposit [2]float64
+}
+
+// Marshal осуществляет бинарный маршалинг данных в w.
+func (d *NevodData) Marshal(w io.Writer) error {
+	if err := d.Event.Marshal(w); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, d.Deposit)
+}
+
+// Unmarshal осуществляет бинарный анмаршалинг данных из r.
+func (d *NevodData) Unmarshal(r io.Reader) error {
+	if err := d.Event.Unmarshal(r); err != nil {
+		return err
+	}
+	return binary.Read(r, binary.LittleEndian, &d.Deposit)
 }
 
 // Copy создает и возвращает копию e
 func (e *ExtEvent) Copy() ExtEvent {
 	decor := make([]DecorTrack, len(e.Decor))
 	copy(decor, e.Decor)
-	return ExtEvent{
+	c := ExtEvent{
 		Ctudc: e.Ctudc.Copy(),
 		Nevod: e.Nevod,
 		Decor: decor,
 	}
+	if e.Full != nil {
+		full := *e.Full
+		c.Full = &full
+	}
+	return c
 }
 
 // Marshal осуществляет бинарный маршалинг события в w.
@@ -126,11 +158,57 @@ func (e *ExtEvent) Marshal(w io.Writer) error {
 	return nil
 }
 
+// MarshalFull осуществляет бинарный маршалинг события вместе с полными данными НЕВОД e.Full.
+func (e *ExtEvent) MarshalFull(w io.Writer) error {
+	if e.Full == nil {
+		return errors.New("ExtEvent has no full nevod data")
+	}
+	if err := e.Marshal(w); err != nil {
+		return err
+	}
+	return e.Full.Marshal(w)
+}
+
+// Максимальное количество треков ДЕКОР события; большее значение означает поврежденные данные.
+const maxDecorTracks = 1 << 16
+
+// UnmarshalFull осуществляет бинарный анмаршалинг события, записанного MarshalFull, из r.
+func (e *ExtEvent) UnmarshalFull(r io.Reader) error {
+	ctudcErr := e.Unmarshal(r)
+	if ctudcErr != nil && ctudcErr != ErrInvalidWire {
+		return ctudcErr
+	}
+	if e.Full == nil {
+		e.Full = new(NevodData)
+	}
+	if err := e.Full.Unmarshal(r); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return ctudcErr
+}
+
 // Unmarshal осуществляет бинарный анмаршалинг события из r.
+// Полные данные НЕВОД e.Full не изменяются.
+// Если запись оборвана, возвращает io.ErrUnexpectedEOF.
+// При ErrInvalidWire событие считывается полностью.
 func (e *ExtEvent) Unmarshal(r io.Reader) error {
-	if err := e.Ctudc.Unmarshal(r); err != nil {
+	ctudcErr := e.Ctudc.Unmarshal(r)
+	if ctudcErr != nil && ctudcErr != ErrInvalidWire {
+		return ctudcErr
+	}
+	if err := e.unmarshalExt(r); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
 		return err
 	}
+	return ctudcErr
+}
+
+func (e *ExtEvent) unmarshalExt(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &e.Nevod); err != nil {
 		return err
 	}
@@ -138,6 +216,9 @@ func (e *ExtEvent) Unmarshal(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
 		return err
 	}
+	if l > maxDecorTracks {
+		return ErrInvalidSize
+	}
 	if int(l) < cap(e.Decor) {
 		e.Decor = e.Decor[:l]
 	} else {
@@ -0,0 +1,212 @@
+package trek
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format определяет тип данных файла.
+type Format string
+
+const (
+	// Необработанные события КТУДК
+	FormatCtudc Format = "ctudc"
+	// События КТУДК, отброшенные при записи
+	FormatDrop Format = "drop"
+	// Объединенные события КТУДК, НЕВОД и ДЕКОР
+	FormatExt Format = "ext"
+)
+
+// Версии форматов.
+// Версия 1 объединенных данных не содержит ExtHeader, версия 2 - содержит.
+const (
+	CtudcVersion = 1
+	ExtVersion   = 2
+)
+
+// maxVersions содержит последние поддерживаемые версии форматов.
+var maxVersions = map[Format]int{
+	FormatCtudc: CtudcVersion,
+	FormatDrop:  CtudcVersion,
+	FormatExt:   ExtVersion,
+}
+
+// HeaderFlags содержит признаки данных файла.
+type HeaderFlags uint32
+
+const (
+	// Данные сжаты. Сжатие при чтении определяется по первым байтам файла, признак носит справочный характер
+	FlagCompressed HeaderFlags = 1 << iota
+	// События содержат метаданные НЕВОД
+	FlagNevod
+	// События содержат треки ДЕКОР
+	FlagDecor
+	// События содержат полные данные НЕВОД
+	FlagFullNevod
+)
+
+var flagNames = []struct {
+	flag HeaderFlags
+	name string
+}{
+	{FlagCompressed, "compressed"},
+	{FlagNevod, "nevod"},
+	{FlagDecor, "decor"},
+	{FlagFullNevod, "full"},
+}
+
+func (f HeaderFlags) String() string {
+	var names []string
+	for _, n := range flagNames {
+		if f&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ",")
+}
+
+// Магическая строка структурированного заголовка.
+const headerMagic = "TDSh"
+
+// legacyHeaders содержит заголовки файлов, записанных до появления структурированного заголовка.
+var legacyHeaders = map[string]FileHeader{
+	"TDSa":     {Format: FormatCtudc, Version: 1},
+	"TDSdrop":  {Format: FormatDrop, Version: 1},
+	"TDS_ext":  {Format: FormatExt, Version: 1, Flags: FlagNevod | FlagDecor},
+	"TDSext_m": {Format: FormatExt, Version: 2, Flags: FlagNevod | FlagDecor},
+}
+
+// FileHeader содержит заголовок файла данных.
+//
+// Заголовок записывается одной строкой вида
+//
+//	TDSh format=ext version=2 created=2006-01-02T15:04:05Z producer=CtudcHandler/1.2.0 run=7 flags=nevod,decor
+//
+// Неизвестные поля и признаки игнорируются, поэтому в заголовок можно добавлять новые поля;
+// несовместимые изменения формата данных требуют увеличения версии.
+type FileHeader struct {
+	Format  Format
+	Version int
+	// Время создания файла; нулевое для старых заголовков
+	Created time.Time
+	// Программа, создавшая файл, и ее версия в виде имя/версия; пустая для старых заголовков
+	Producer string
+	// Номер рана; -1, если неизвестен
+	Run   int
+	Flags HeaderFlags
+}
+
+// NewFileHeader возвращает заголовок последней версии формата format.
+func NewFileHeader(format Format, producer string, run int, flags HeaderFlags) *FileHeader {
+	return &FileHeader{
+		Format:   format,
+		Version:  maxVersions[format],
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Producer: producer,
+		Run:      run,
+		Flags:    flags,
+	}
+}
+
+// String возвращает строку заголовка без перевода строки.
+func (h *FileHeader) String() string {
+	return fmt.Sprintf("%s format=%s version=%d created=%s producer=%s run=%d flags=%s", headerMagic,
+		h.Format, h.Version, h.Created.UTC().Format(time.RFC3339), strings.Join(strings.Fields(h.Producer), "_"), h.Run, h.Flags)
+}
+
+// ParseHeader разбирает строку заголовка line, структурированного или старого.
+// Возвращает ошибку, если формат неизвестен или его версия не поддерживается.
+func ParseHeader(line string) (*FileHeader, error) {
+	line = strings.TrimSpace(line)
+	if h, ok := legacyHeaders[line]; ok {
+		h.Run = -1
+		return &h, nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != headerMagic {
+		return nil, fmt.Errorf("unknown header %q", line)
+	}
+	h := &FileHeader{Run: -1}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header field %q", field)
+		}
+		var err error
+		switch key, value := kv[0], kv[1]; key {
+		case "format":
+			h.Format = Format(value)
+		case "version":
+			h.Version, err = strconv.Atoi(value)
+		case "created":
+			h.Created, err = time.Parse(time.RFC3339, value)
+		case "producer":
+			h.Producer = value
+		case "run":
+			h.Run, err = strconv.Atoi(value)
+		case "flags":
+			h.Flags = parseFlags(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid header field %q: %s", field, err)
+		}
+	}
+	maxVersion, ok := maxVersions[h.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown data format %q", h.Format)
+	}
+	if h.Version < 1 || h.Version > maxVersion {
+		return nil, fmt.Errorf("unsupported %s format version %d (supported 1-%d)", h.Format, h.Version, maxVersion)
+	}
+	return h, nil
+}
+
+// AddHeaderFlags возвращает строку структурированного заголовка line с добавленными признаками flags.
+// Остальные поля, в том числе неизвестные, и завершающий перевод строки сохраняются.
+// Старые заголовки не содержат признаков и возвращаются без изменений.
+func AddHeaderFlags(line string, flags HeaderFlags) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != headerMagic {
+		return line
+	}
+	tail := line[len(strings.TrimRight(line, " \t\r\n")):]
+	for i, field := range fields {
+		if !strings.HasPrefix(field, "flags=") {
+			continue
+		}
+		value := strings.TrimPrefix(field, "flags=")
+		var names []string
+		if value != "-" {
+			names = strings.Split(value, ",")
+		}
+		present := parseFlags(value)
+		for _, n := range flagNames {
+			if flags&n.flag != 0 && present&n.flag == 0 {
+				names = append(names, n.name)
+			}
+		}
+		if len(names) == 0 {
+			names = []string{"-"}
+		}
+		fields[i] = "flags=" + strings.Join(names, ",")
+		return strings.Join(fields, " ") + tail
+	}
+	return strings.Join(append(fields, "flags="+flags.String()), " ") + tail
+}
+
+func parseFlags(s string) HeaderFlags {
+	var flags HeaderFlags
+	for _, name := range strings.Split(s, ",") {
+		for _, n := range flagNames {
+			if n.name == name {
+				flags |= n.flag
+			}
+		}
+	}
+	return flags
+}
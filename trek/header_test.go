@@ -0,0 +1,56 @@
+package trek
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	h := NewFileHeader(FormatExt, "CtudcHandler/1.2.0", 7, FlagNevod|FlagDecor|FlagCompressed)
+	h.Created = time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	parsed, err := ParseHeader(h.String() + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *h {
+		t.Fatalf("header %+v, expected %+v", parsed, h)
+	}
+
+	legacy, err := ParseHeader("TDSext_m\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Format != FormatExt || legacy.Version != 2 || legacy.Flags != FlagNevod|FlagDecor || legacy.Run != -1 {
+		t.Errorf("invalid legacy header %+v", legacy)
+	}
+
+	// Неизвестные поля и признаки игнорируются.
+	if _, err := ParseHeader("TDSh format=ctudc version=1 run=3 flags=nevod,future future=1"); err != nil {
+		t.Error(err)
+	}
+	for _, line := range []string{
+		"TDSh format=ext version=3",
+		"TDSh format=unknown version=1",
+		"TDSb",
+	} {
+		if _, err := ParseHeader(line); err == nil {
+			t.Errorf("header %q is accepted", line)
+		}
+	}
+}
+
+func TestAddHeaderFlags(t *testing.T) {
+	for _, c := range []struct {
+		line, expected string
+	}{
+		{"TDSh format=ext version=2 run=7 flags=nevod,future extra=1\n", "TDSh format=ext version=2 run=7 flags=nevod,future,compressed extra=1\n"},
+		{"TDSh format=ctudc version=1 run=7 flags=-\n", "TDSh format=ctudc version=1 run=7 flags=compressed\n"},
+		{"TDSh format=ctudc version=1 flags=compressed", "TDSh format=ctudc version=1 flags=compressed"},
+		{"TDSh format=ctudc version=1\n", "TDSh format=ctudc version=1 flags=compressed\n"},
+		{"TDSa\n", "TDSa\n"},
+	} {
+		if line := AddHeaderFlags(c.line, FlagCompressed); line != c.expected {
+			t.Errorf("AddHeaderFlags(%q) == %q, expected %q", c.line, line, c.expected)
+		}
+	}
+}
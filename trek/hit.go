@@ -23,6 +23,9 @@ const (
 	Trailing
 )
 
+// hitTypeMask маска битов канала, содержащих тип хита.
+const hitTypeMask = 0xF0000000
+
 // Hit содержит информацию о хите TDC.
 type Hit struct {
 	channel uint32
@@ -55,25 +58,27 @@ func (h Hit) Type() HitType {
 }
 
 // Unmarshal осуществляет десериализацию данных хита в r.
+// Если номер проволоки больше 3, хит считывается полностью и возвращается ErrInvalidWire.
 func (h *Hit) Unmarshal(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &h.channel); err != nil {
 		return err
 	}
-	h.channel = uint32((3 - h.Wire()) | (h.Chamber() << 8))
-	if h.Wire() > 3 {
-		panic("Hit Unmarshal h.Wire() > 3")
-	}
+	h.channel = uint32((3-h.Wire())|(h.Chamber()<<8)) | h.channel&hitTypeMask
 	if err := binary.Read(r, binary.LittleEndian, &h.time); err != nil {
 		return err
 	}
+	if h.Wire() > 3 {
+		return ErrInvalidWire
+	}
 	return nil
 }
 
 // Marshal осуществляет сериализацию данных хита в w.
+// Если номер проволоки больше 3, возвращает ErrInvalidWire.
 func (h Hit) Marshal(w io.Writer) error {
-	channel := uint32((3 - h.Wire()) | (h.Chamber() << 8))
+	channel := uint32((3-h.Wire())|(h.Chamber()<<8)) | h.channel&hitTypeMask
 	if channel&0xFF > 3 {
-		panic("Hit Marshal h.Wire() > 3")
+		return ErrInvalidWire
 	}
 	if err := binary.Write(w, binary.LittleEndian, channel); err != nil {
 		return err
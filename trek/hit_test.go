@@ -0,0 +1,91 @@
+package trek
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func rawHit(cham, wire int, t HitType, time uint32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(3-wire)|uint32(cham)<<8|uint32(t)<<28)
+	binary.Write(&buf, binary.LittleEndian, time)
+	return buf.Bytes()
+}
+
+func TestHitType(t *testing.T) {
+	var h Hit
+	if err := h.Unmarshal(bytes.NewReader(rawHit(5, 1, Trailing, 100))); err != nil {
+		t.Fatal(err)
+	}
+	if h.Chamber() != 5 || h.Wire() != 1 || h.Type() != Trailing || h.Time() != 100 {
+		t.Fatalf("invalid hit %v", h)
+	}
+	var buf bytes.Buffer
+	if err := h.Marshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), rawHit(5, 1, Trailing, 100)) {
+		t.Error("Marshal does not preserve hit type")
+	}
+}
+
+func TestEventPulses(t *testing.T) {
+	var data []byte
+	for _, h := range []struct {
+		t    HitType
+		time uint32
+	}{{Leading, 100}, {Trailing, 130}, {Leading, 200}, {Leading, 300}, {Trailing, 350}} {
+		data = append(data, rawHit(2, 3, h.t, h.time)...)
+	}
+	var e Event
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var h Hit
+		if err := h.Unmarshal(r); err != nil {
+			t.Fatal(err)
+		}
+		e.hits = append(e.hits, h)
+	}
+	pulses := e.Pulses()[2][3]
+	expected := []Pulse{{100, 130, true}, {200, 0, false}, {300, 350, true}}
+	if len(pulses) != len(expected) {
+		t.Fatalf("pulses == %v", pulses)
+	}
+	for i := range expected {
+		if pulses[i] != expected[i] {
+			t.Errorf("pulses[%d] == %v", i, pulses[i])
+		}
+	}
+	if pulses[0].ToT() != 30 {
+		t.Errorf("pulses[0].ToT() == %d", pulses[0].ToT())
+	}
+	if times := e.ChamberTimes(2); len(times[3]) != 3 {
+		t.Errorf("e.ChamberTimes(2) == %v", times)
+	}
+}
+
+func TestEventUnmarshalErrors(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(1))
+	binary.Write(&buf, binary.LittleEndian, uint64(5))
+	binary.Write(&buf, binary.LittleEndian, int64(1000))
+	binary.Write(&buf, binary.LittleEndian, uint32(2))
+	buf.Write(rawHit(1, 0, Leading, 10))
+	// Сырой номер проволоки 7 выходит за пределы 0..3.
+	buf.Write(rawHit(1, -4, Leading, 20))
+	data := append(buf.Bytes(), buf.Bytes()[:20]...)
+
+	r := bytes.NewReader(data)
+	var e Event
+	if err := e.Unmarshal(r); err != ErrInvalidWire {
+		t.Fatalf("invalid wire: %v", err)
+	}
+	if e.Nevent() != 5 || len(e.Hits()) != 2 {
+		t.Fatalf("event %d with %d hits", e.Nevent(), len(e.Hits()))
+	}
+	if err := e.Unmarshal(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated event: %v", err)
+	}
+}
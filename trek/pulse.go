@@ -0,0 +1,56 @@
+package trek
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pulse содержит импульс с проволоки: пару leading и trailing фронтов.
+type Pulse struct {
+	// Время leading фронта.
+	Leading uint
+	// Время trailing фронта.
+	Trailing uint
+	// Признак наличия trailing фронта.
+	Paired bool
+}
+
+// ToT возвращает время над порогом (time-over-threshold) импульса.
+// Для импульса без trailing фронта возвращает 0.
+func (p Pulse) ToT() uint {
+	if !p.Paired {
+		return 0
+	}
+	return p.Trailing - p.Leading
+}
+
+// String создает строку с описанием импульса в формате [leading, trailing]: ToT.
+func (p Pulse) String() string {
+	if !p.Paired {
+		return fmt.Sprintf("[%d, -]", p.Leading)
+	}
+	return fmt.Sprintf("[%d, %d]: %d", p.Leading, p.Trailing, p.ToT())
+}
+
+// ChamPulses содержит импульсы одной камеры в формате [wire][row]Pulse.
+type ChamPulses [4][]Pulse
+
+// mkPulses составляет импульсы из фронтов одной проволоки.
+// Каждому leading фронту ставится в пару ближайший следующий trailing фронт,
+// если между ними нет другого leading фронта.
+func mkPulses(hits []Hit) []Pulse {
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Time() < hits[j].Time() })
+	var pulses []Pulse
+	for i, h := range hits {
+		if h.Type() != Leading {
+			continue
+		}
+		p := Pulse{Leading: h.Time()}
+		if i+1 < len(hits) && hits[i+1].Type() == Trailing {
+			p.Trailing = hits[i+1].Time()
+			p.Paired = true
+		}
+		pulses = append(pulses, p)
+	}
+	return pulses
+}
@@ -0,0 +1,91 @@
+package trek
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// RTable содержит нелинейную зависимость расстояния дрейфа от времени r(t) для одной проволоки.
+type RTable struct {
+	// Время первого узла таблицы.
+	Start uint `json:"start"`
+	// Шаг таблицы по времени.
+	Step uint `json:"step"`
+	// Расстояния дрейфа в узлах таблицы.
+	Dists []float64 `json:"dists"`
+}
+
+// NewRTable создает таблицу из n узлов на интервале [start, stop].
+func NewRTable(start, stop uint, n int) RTable {
+	step := uint(1)
+	if n > 1 && stop > start {
+		step = (stop - start) / uint(n-1)
+	}
+	if step == 0 {
+		step = 1
+	}
+	return RTable{
+		Start: start,
+		Step:  step,
+		Dists: make([]float64, n),
+	}
+}
+
+// Valid возвращает true, если таблица содержит хотя бы два узла.
+func (t *RTable) Valid() bool {
+	return len(t.Dists) > 1 && t.Step > 0
+}
+
+// Stop возвращает время последнего узла таблицы.
+func (t *RTable) Stop() uint {
+	return t.Start + t.Step*uint(len(t.Dists)-1)
+}
+
+// Bin возвращает номер узла, ближайшего к времени time слева.
+// Если time выходит за пределы таблицы, возвращает false.
+func (t *RTable) Bin(time uint) (int, bool) {
+	if !t.Valid() || time < t.Start || time > t.Stop() {
+		return 0, false
+	}
+	bin := int((time - t.Start) / t.Step)
+	if bin >= len(t.Dists)-1 {
+		bin = len(t.Dists) - 2
+	}
+	return bin, true
+}
+
+// Dist возвращает расстояние дрейфа для времени time, полученное линейной интерполяцией.
+// Если time выходит за пределы таблицы, возвращает false.
+func (t *RTable) Dist(time uint) (float64, bool) {
+	bin, ok := t.Bin(time)
+	if !ok {
+		return 0, false
+	}
+	t0 := t.Start + uint(bin)*t.Step
+	frac := float64(time-t0) / float64(t.Step)
+	return t.Dists[bin] + frac*(t.Dists[bin+1]-t.Dists[bin]), true
+}
+
+// Calibration содержит таблицы r(t) проволок дрейфовых камер в формате [chamber]*[wire]RTable.
+type Calibration map[int]*[4]RTable
+
+// ReadCalibration считывает калибровку в формате JSON из r.
+func ReadCalibration(r io.Reader) (Calibration, error) {
+	calib := make(Calibration)
+	if err := json.NewDecoder(r).Decode(&calib); err != nil {
+		return nil, err
+	}
+	return calib, nil
+}
+
+// Write записывает калибровку в формате JSON в w.
+func (c Calibration) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package trek
+
+import (
+	"testing"
+)
+
+func TestRTableDist(t *testing.T) {
+	rt := NewRTable(100, 200, 3)
+	rt.Dists[0], rt.Dists[1], rt.Dists[2] = 0, 10, 30
+	cases := []struct {
+		time uint
+		dist float64
+	}{
+		{100, 0},
+		{125, 5},
+		{150, 10},
+		{175, 20},
+		{200, 30},
+	}
+	for _, c := range cases {
+		if d, ok := rt.Dist(c.time); !ok || d != c.dist {
+			t.Errorf("rt.Dist(%d) == %v, %v", c.time, d, ok)
+		}
+	}
+	if _, ok := rt.Dist(99); ok {
+		t.Error("rt.Dist(99) is valid")
+	}
+	if _, ok := rt.Dist(201); ok {
+		t.Error("rt.Dist(201) is valid")
+	}
+}
@@ -2,6 +2,7 @@ package trek
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -9,56 +10,115 @@ import (
 
 // Scanner осуществляет последовательное считывание событий КТУДК.
 type Scanner struct {
-	header string
-	reader *bufio.Reader
-	event  Event
-	err    error
+	name    string
+	header  string
+	file    *FileHeader
+	counter *CountingReader
+	reader  *bufio.Reader
+	event   Event
+	offset  int64
+	last    int64
+	err     error
 }
 
-func readHeader(r *bufio.Reader) (string, error) {
+// CountingReader подсчитывает количество прочитанных байт.
+type CountingReader struct {
+	r io.Reader
+	n int64
+}
+
+// NewCountingReader возвращает CountingReader, читающий из r.
+func NewCountingReader(r io.Reader) *CountingReader {
+	return &CountingReader{r: r}
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Count возвращает количество прочитанных байт.
+func (c *CountingReader) Count() int64 {
+	return c.n
+}
+
+func readHeader(r *bufio.Reader) (string, *FileHeader, error) {
 	header, err := r.ReadString('\n')
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	header = strings.TrimSpace(header)
-	return header, nil
+	file, err := ParseHeader(header)
+	if err != nil {
+		return "", nil, err
+	}
+	if file.Format != FormatCtudc && file.Format != FormatDrop {
+		return "", nil, fmt.Errorf("%s data is not CTUDC events", file.Format)
+	}
+	return header, file, nil
 }
 
 // NewScanner возвращает новый Scanner, читающиц из r.
+// Сжатые данные распаковываются, смещения событий отсчитываются в распакованном потоке.
 func NewScanner(r io.Reader) (*Scanner, error) {
-	reader := bufio.NewReader(r)
-	header, err := readHeader(reader)
+	r, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	counter := NewCountingReader(r)
+	reader := bufio.NewReader(counter)
+	header, file, err := readHeader(reader)
 	if err != nil {
 		return nil, err
 	}
 	return &Scanner{
-		header: header,
-		reader: reader,
+		header:  header,
+		file:    file,
+		counter: counter,
+		reader:  reader,
+		last:    -1,
 	}, nil
 }
 
+// SetName задает имя файла, указываемое в ошибках чтения.
+func (s *Scanner) SetName(name string) {
+	s.name = name
+}
+
+// Reset начинает чтение событий из r. Возвращает ошибку, если заголовок данных не поддерживается.
 func (s *Scanner) Reset(r io.Reader) error {
-	s.reader.Reset(r)
-	header, err := readHeader(s.reader)
+	r, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+	s.counter = NewCountingReader(r)
+	s.reader.Reset(s.counter)
+	header, file, err := readHeader(s.reader)
 	if err != nil {
-		return nil
+		return err
 	}
-	s.header = header
+	s.header, s.file = header, file
 	s.event = Event{0, 0, time.Now(), nil}
+	s.last = -1
 	s.err = nil
 	return nil
 }
 
 // Scan считывает следующее событие. В случае успеха возвращает true,
 // при возникновении ошибки возвращает false. После того как Scan возвращает false,
-// метод Err возвращает ошибку(если ошибка - io.EOF, возвращает nil).
+// метод Err возвращает ошибку *DataError (если ошибка - io.EOF, возвращает nil).
+// Если ошибка вызвана ErrInvalidWire, событие считано целиком и чтение можно продолжить.
 func (s *Scanner) Scan() bool {
+	s.err = nil
+	s.offset = s.counter.n - int64(s.reader.Buffered())
 	err := s.event.Unmarshal(s.reader)
 	if err == nil {
+		s.last = int64(s.event.Nevent())
 		return true
 	}
 	if err != io.EOF {
-		s.err = err
+		s.err = &DataError{File: s.name, Offset: s.offset, Nevent: s.last, Err: err}
 	}
 	return false
 }
@@ -68,11 +128,21 @@ func (s *Scanner) Record() *Event {
 	return &s.event
 }
 
+// Offset возвращает смещение последнего прочитанного события от начала потока в байтах.
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
 // Header Возвращает заголовок данных
 func (s *Scanner) Header() string {
 	return s.header
 }
 
+// FileHeader возвращает разобранный заголовок данных.
+func (s *Scanner) FileHeader() *FileHeader {
+	return s.file
+}
+
 // Err Возвращает ошибку, произошедшую при чтении.
 func (s *Scanner) Err() error {
 	return s.err
@@ -0,0 +1,117 @@
+package trek
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestScannerOffset(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("TDSa\n")
+	var offsets []int64
+	for n := 0; n < 3; n++ {
+		offsets = append(offsets, int64(buf.Len()))
+		e := Event{nRun: 1, nEvent: uint64(n + 10), time: time.Unix(int64(n), 0), hits: make([]Hit, n)}
+		if err := e.Marshal(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+	s, err := NewScanner(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; s.Scan(); i++ {
+		if s.Offset() != offsets[i] {
+			t.Fatalf("event %d: offset %d, expected %d", s.Record().Nevent(), s.Offset(), offsets[i])
+		}
+		var e Event
+		if err := e.Unmarshal(bytes.NewReader(data[s.Offset():])); err != nil || e.Nevent() != s.Record().Nevent() {
+			t.Fatalf("event at offset %d is %d, expected %d", s.Offset(), e.Nevent(), s.Record().Nevent())
+		}
+	}
+	if s.Err() != nil {
+		t.Fatal(s.Err())
+	}
+}
+
+func TestScannerCompressed(t *testing.T) {
+	var plain bytes.Buffer
+	plain.WriteString("TDSa\n")
+	for n := 0; n < 3; n++ {
+		e := Event{nRun: 1, nEvent: uint64(n), time: time.Unix(int64(n), 0), hits: make([]Hit, n)}
+		if err := e.Marshal(&plain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range []struct {
+		name string
+		new  func(io.Writer) (io.WriteCloser, error)
+	}{
+		{"gzip", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }},
+		{"zstd", func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }},
+	} {
+		var compressed bytes.Buffer
+		zw, err := w.new(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(plain.Bytes())
+		zw.Close()
+
+		s, err := NewScanner(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Header() != "TDSa" {
+			t.Fatalf("%s: invalid header %q", w.name, s.Header())
+		}
+		var n uint
+		for ; s.Scan(); n++ {
+			if s.Record().Nevent() != n || len(s.Record().Hits()) != int(n) {
+				t.Fatalf("%s: invalid event %d", w.name, s.Record().Nevent())
+			}
+		}
+		if s.Err() != nil || n != 3 {
+			t.Fatalf("%s: read %d events: %v", w.name, n, s.Err())
+		}
+	}
+}
+
+func TestScannerDataError(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("TDSa\n")
+	for n, wire := range []int{0, -4, 1} {
+		binary.Write(&buf, binary.LittleEndian, uint64(1))
+		binary.Write(&buf, binary.LittleEndian, uint64(n))
+		binary.Write(&buf, binary.LittleEndian, int64(n))
+		binary.Write(&buf, binary.LittleEndian, uint32(1))
+		buf.Write(rawHit(2, wire, Leading, 10))
+	}
+	s, err := NewScanner(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetName("test.tds")
+	if !s.Scan() || s.Scan() {
+		t.Fatal("event with invalid wire is accepted")
+	}
+	var dataErr *DataError
+	if !errors.As(s.Err(), &dataErr) || !errors.Is(s.Err(), ErrInvalidWire) {
+		t.Fatalf("invalid error %v", s.Err())
+	}
+	if dataErr.File != "test.tds" || dataErr.Nevent != 0 || dataErr.Offset != 5+28+8 {
+		t.Errorf("invalid error context %+v", dataErr)
+	}
+	// Событие с недопустимым хитом считано целиком, чтение продолжается.
+	if !s.Scan() || s.Record().Nevent() != 2 {
+		t.Fatalf("failed continue after invalid wire: %v", s.Err())
+	}
+}
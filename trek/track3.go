@@ -0,0 +1,115 @@
+package trek
+
+import (
+	"math"
+	"sort"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+// Максимальный косинус угла между плоскостями камер, при котором их проекции объединяются.
+const maxPlanesCos = 0.95
+
+// Track3Desc содержит описание трека, восстановленного в пространстве по двум камерам.
+type Track3Desc struct {
+	// Прямая трека в системе координат НЕВОД, направляющий вектор единичный и направлен вниз.
+	Line geo.Line3
+	// Номера камер, по которым был восстановлен трек.
+	Chambers [2]int
+	// Треки в камерах, по которым был восстановлен трек.
+	Tracks [2]TrackDesc
+}
+
+// Cosines возвращает направляющие косинусы трека.
+func (t *Track3Desc) Cosines() geo.Vec3 {
+	return t.Line.Vector
+}
+
+// Zenith возвращает зенитный угол трека в радианах.
+func (t *Track3Desc) Zenith() float64 {
+	return Zenith(t.Line.Vector)
+}
+
+// Azimuth возвращает азимутальный угол трека в радианах.
+func (t *Track3Desc) Azimuth() float64 {
+	return Azimuth(t.Line.Vector)
+}
+
+// Zenith возвращает зенитный угол направления v.
+func Zenith(v geo.Vec3) float64 {
+	v = v.Ort()
+	return math.Acos(math.Abs(v.Z))
+}
+
+// Azimuth возвращает азимутальный угол направления прихода частицы,
+// движущейся вдоль v, в диапазоне [0, 2π).
+func Azimuth(v geo.Vec3) float64 {
+	if v.Z > 0 {
+		v = v.Mul(-1)
+	}
+	phi := math.Atan2(-v.Y, -v.X)
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	return phi
+}
+
+// SpaceAngle возвращает угол между направлениями v1 и v2 без учета знака направлений.
+func SpaceAngle(v1, v2 geo.Vec3) float64 {
+	cos := math.Abs(v1.Ort().Dot(v2.Ort()))
+	return math.Acos(math.Min(cos, 1))
+}
+
+// CreateTracks3 объединяет треки tracks пересекающихся камер одной группы
+// в пространственные треки. Трек принимается, если он пересекает обе камеры.
+func CreateTracks3(chambers map[int]*Chamber, tracks map[int][]TrackDesc) []Track3Desc {
+	var numbers []int
+	for cham := range tracks {
+		numbers = append(numbers, cham)
+	}
+	sort.Ints(numbers)
+
+	var result []Track3Desc
+	for n, cham1 := range numbers {
+		for _, cham2 := range numbers[n+1:] {
+			c1, c2 := chambers[cham1], chambers[cham2]
+			if c1 == nil || c2 == nil || !c1.crosses(c2) {
+				continue
+			}
+			tracks1, tracks2 := tracks[cham1], tracks[cham2]
+			for i := range tracks1 {
+				for j := range tracks2 {
+					p1, err1 := c1.TrackPlane(&tracks1[i])
+					p2, err2 := c2.TrackPlane(&tracks2[j])
+					if err1 != nil || err2 != nil {
+						continue
+					}
+					line, err := p1.CrossPlane(p2)
+					if err != nil || !c1.hex.Crossing(line) || !c2.hex.Crossing(line) {
+						continue
+					}
+					if line.Vector.Z > 0 {
+						line.Vector = line.Vector.Mul(-1)
+					}
+					result = append(result, Track3Desc{
+						Line:     line,
+						Chambers: [2]int{cham1, cham2},
+						Tracks:   [2]TrackDesc{tracks1[i], tracks2[j]},
+					})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// crosses возвращает true, если камеры c и oc принадлежат одной группе,
+// разным плоскостям и их проволоки не параллельны.
+func (c *Chamber) crosses(oc *Chamber) bool {
+	if c.desc.Group != oc.desc.Group || c.desc.Plane == oc.desc.Plane {
+		return false
+	}
+	wire1 := c.coord.RestoreLine(geo.Line3{Vector: geo.Vec3{X: 0, Y: 0, Z: 1}}).Vector
+	wire2 := oc.coord.RestoreLine(geo.Line3{Vector: geo.Vec3{X: 0, Y: 0, Z: 1}}).Vector
+	return math.Abs(wire1.Dot(wire2)) < maxPlanesCos
+}
@@ -0,0 +1,34 @@
+package trek
+
+import (
+	"testing"
+
+	geo "github.com/frostoov/CtudcHandler/math"
+)
+
+func TestCreateTracks3(t *testing.T) {
+	c1 := NewChamber(ChamberDesc{
+		Points: [3]geo.Vec3{{X: 0, Y: 0, Z: 0}, {X: 500, Y: 0, Z: 0}, {X: 0, Y: 4000, Z: 0}},
+		Plane:  1,
+	})
+	c2 := NewChamber(ChamberDesc{
+		Points: [3]geo.Vec3{{X: 0, Y: 0, Z: 0}, {X: 0, Y: 500, Z: 0}, {X: 4000, Y: 0, Z: 0}},
+		Plane:  2,
+	})
+	muon := geo.Line3{Point: geo.Vec3{X: 100, Y: 200, Z: 0}, Vector: geo.Vec3{X: 0.1, Y: 0.2, Z: -1}}
+	chambers := map[int]*Chamber{0: c1, 1: c2}
+	tracks := map[int][]TrackDesc{
+		0: {{Line: c1.LineProjection(muon)}},
+		1: {{Line: c2.LineProjection(muon)}},
+	}
+	tracks3 := CreateTracks3(chambers, tracks)
+	if len(tracks3) != 1 {
+		t.Fatalf("len(CreateTracks3()) == %d", len(tracks3))
+	}
+	if angle := SpaceAngle(tracks3[0].Line.Vector, muon.Vector); angle > 1e-9 {
+		t.Errorf("SpaceAngle == %v", angle)
+	}
+	if tracks3[0].Line.Vector.Z > 0 {
+		t.Error("track vector is directed upwards")
+	}
+}
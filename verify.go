@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	path "path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frostoov/CtudcHandler/trek"
+)
+
+const (
+	// Максимальный допустимый интервал между соседними событиями.
+	verifyMaxTimeJump = 10 * time.Minute
+	// Количество выводимых в лог примеров нарушений каждого вида.
+	verifyMaxExamples = 10
+)
+
+// Виды нарушений целостности данных.
+const (
+	issueHeader    = "header"
+	issueTruncated = "truncated"
+	issueCorrupted = "corrupted"
+	issueChannel   = "channel"
+	issueRun       = "run"
+	issueOrder     = "order"
+	issueDuplicate = "duplicate"
+	issueTime      = "time"
+	issueRange     = "range"
+	issueGap       = "gap"
+)
+
+// verifyReport содержит результат проверки данных рана.
+type verifyReport struct {
+	run    int
+	files  int
+	events int
+	issues map[string]int
+}
+
+func (r *verifyReport) total() int {
+	n := 0
+	for _, count := range r.issues {
+		n += count
+	}
+	return n
+}
+
+func (r *verifyReport) String() string {
+	if r.total() == 0 {
+		return fmt.Sprintf("run %d: files %d, events %d: ok", r.run, r.files, r.events)
+	}
+	var kinds []string
+	for kind := range r.issues {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%s %d", kind, r.issues[kind])
+	}
+	return fmt.Sprintf("run %d: files %d, events %d: %s", r.run, r.files, r.events, strings.Join(kinds, ", "))
+}
+
+// verifier проверяет последовательность событий одного потока данных рана.
+type verifier struct {
+	report   *verifyReport
+	chambers map[int]bool
+	stream   string
+
+	file     string
+	seen     map[uint]bool
+	prev     uint
+	prevTime time.Time
+	min, max uint
+}
+
+func newVerifier(report *verifyReport, chambers map[int]bool, stream string) *verifier {
+	return &verifier{
+		report:   report,
+		chambers: chambers,
+		stream:   stream,
+		seen:     make(map[uint]bool),
+	}
+}
+
+// issue учитывает нарушение вида kind в позиции offset текущего файла.
+func (v *verifier) issue(kind string, offset int64, format string, args ...interface{}) {
+	v.report.issues[kind]++
+	if v.report.issues[kind] <= verifyMaxExamples {
+		log.Printf("Run %d %s %s@%d: %s: %s\n", v.report.run, v.stream, path.Base(v.file), offset, kind, fmt.Sprintf(format, args...))
+	}
+}
+
+// check проверяет событие e, прочитанное со смещения offset.
+func (v *verifier) check(e *trek.Event, offset int64) {
+	nevent := e.Nevent()
+	if e.Nrun() != uint(v.report.run) {
+		v.issue(issueRun, offset, "event %d has run %d", nevent, e.Nrun())
+	}
+	for _, h := range e.Hits() {
+		if v.chambers != nil && !v.chambers[h.Chamber()] {
+			v.issue(issueChannel, offset, "event %d has hit of unknown chamber %d", nevent, h.Chamber()+1)
+		}
+	}
+	if len(v.seen) != 0 {
+		if v.seen[nevent] {
+			v.issue(issueDuplicate, offset, "event %d", nevent)
+		} else if nevent < v.prev {
+			v.issue(issueOrder, offset, "event %d after %d", nevent, v.prev)
+		}
+		if !e.Time().IsZero() && !v.prevTime.IsZero() {
+			if dt := e.Time().Sub(v.prevTime); dt < 0 || dt > verifyMaxTimeJump {
+				v.issue(issueTime, offset, "event %d time jump %v", nevent, dt)
+			}
+		}
+	}
+	if len(v.seen) == 0 || nevent < v.min {
+		v.min = nevent
+	}
+	if len(v.seen) == 0 || nevent > v.max {
+		v.max = nevent
+	}
+	v.seen[nevent] = true
+	v.prev = nevent
+	if !e.Time().IsZero() {
+		v.prevTime = e.Time()
+	}
+	v.report.events++
+}
+
+// readError учитывает ошибку чтения события со смещения offset.
+// Возвращает true, если чтение файла можно продолжить.
+func (v *verifier) readError(err error, offset int64) bool {
+	switch err {
+	case trek.ErrInvalidWire:
+		v.issue(issueChannel, offset, "hit wire number > 3")
+		return true
+	case io.ErrUnexpectedEOF:
+		v.issue(issueTruncated, offset, "record is truncated")
+	case trek.ErrInvalidSize:
+		v.issue(issueCorrupted, offset, "invalid record size")
+	default:
+		v.issue(issueCorrupted, offset, "%s", err)
+	}
+	return false
+}
+
+// verifyFile содержит проверяемый файл данных.
+type verifyFile struct {
+	f       *dataReader
+	r       *bufio.Reader
+	counter *trek.CountingReader
+	line    string
+	header  *trek.FileHeader
+}
+
+// offset возвращает смещение следующей записи файла.
+func (f *verifyFile) offset() int64 {
+	return f.counter.Count() - int64(f.r.Buffered())
+}
+
+// open открывает файл данных filename, читает и проверяет его заголовок.
+// Если заголовок некорректен, нарушение учитывается и возвращается nil.
+func (v *verifier) open(filename string) (*verifyFile, error) {
+	v.file = filename
+	v.report.files++
+	f, err := openData(filename)
+	if err != nil {
+		return nil, err
+	}
+	file := &verifyFile{f: f, counter: trek.NewCountingReader(f)}
+	file.r = bufio.NewReader(file.counter)
+	if file.line, err = file.r.ReadString('\n'); err != nil {
+		f.Close()
+		v.issue(issueHeader, 0, "failed read header: %s", err)
+		return nil, nil
+	}
+	if file.header, err = trek.ParseHeader(file.line); err != nil {
+		f.Close()
+		v.issue(issueHeader, 0, "%s", err)
+		return nil, nil
+	}
+	if file.header.Run >= 0 && file.header.Run != v.report.run {
+		v.issue(issueHeader, 0, "header has run %d", file.header.Run)
+	}
+	return file, nil
+}
+
+// verifyCtudcFiles проверяет файлы КТУДК paths.
+func (v *verifier) verifyCtudcFiles(paths []string) error {
+	for _, filename := range paths {
+		file, err := v.open(filename)
+		if err != nil {
+			return err
+		} else if file == nil {
+			continue
+		}
+		if file.header.Format == trek.FormatExt {
+			v.issue(issueHeader, 0, "merged data in CTUDC directory")
+			file.f.Close()
+			continue
+		}
+		var e trek.Event
+		for {
+			offset := file.offset()
+			err := e.Unmarshal(file.r)
+			if err == io.EOF {
+				break
+			} else if err != nil && !v.readError(err, offset) {
+				break
+			}
+			v.check(&e, offset)
+		}
+		file.f.Close()
+	}
+	return nil
+}
+
+// verifyExtFile проверяет объединенный файл filename.
+func (v *verifier) verifyExtFile(filename string) error {
+	file, err := v.open(filename)
+	if err != nil {
+		return err
+	} else if file == nil {
+		return nil
+	}
+	defer file.f.Close()
+	er, err := newExtReader(file.r, file.line)
+	if err != nil {
+		v.issue(issueHeader, 0, "%s", err)
+		return nil
+	}
+	var e trek.ExtEvent
+	for {
+		offset := file.offset()
+		err := er.Read(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil && !v.readError(err, offset) {
+			break
+		}
+		v.check(&e.Ctudc, offset)
+	}
+	// Объединенные данные содержат только сопоставленные события,
+	// поэтому проверяется лишь выход событий за пределы рана.
+	if er.file.Version >= 2 && len(v.seen) != 0 {
+		first, last := uint(er.header.FirstEvent), uint(er.header.LastEvent)
+		if v.min < first || v.max > last {
+			v.issue(issueRange, 0, "events [%d, %d] are out of run range [%d, %d]", v.min, v.max, first, last)
+		}
+	}
+	return nil
+}
+
+// verifyRun проверяет файлы КТУДК и объединенные данные рана run.
+func verifyRun(run int) (*verifyReport, error) {
+	report := &verifyReport{run: run, issues: make(map[string]int)}
+	var chambers map[int]bool
+	if config, err := readChamberConfig(formatChamberConfig(run)); err == nil {
+		chambers = make(map[int]bool)
+		for i := range config {
+			chambers[config[i].Number] = true
+		}
+	} else {
+		log.Printf("Run %d: chamber numbers are not checked: %s\n", run, err)
+	}
+	set, err := readFileSet(formatCtudcSubdir(run), ".tds")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if set != nil {
+		if gaps := set.Gaps(); len(gaps) != 0 {
+			report.issues[issueGap] = len(gaps)
+			log.Printf("Run %d: missing files %s\n", run, strings.Join(gaps, ", "))
+		}
+		if err := newVerifier(report, chambers, "ctudc").verifyCtudcFiles(set.Paths()); err != nil {
+			return nil, err
+		}
+	}
+	extname, extErr := findData(formatExtFilename(run))
+	if extErr == nil {
+		if err := newVerifier(report, chambers, "ext").verifyExtFile(extname); err != nil {
+			return nil, err
+		}
+	}
+	if set == nil && extErr != nil {
+		return nil, fmt.Errorf("no data of run %d", run)
+	}
+	return report, nil
+}
+
+// verify проверяет целостность данных ранов runs.
+// Раны, данные которых содержат нарушения, считаются неудачными.
+func verify(runs []int) error {
+	failed := processRuns(runs, *jobs, func(run int) (interface{}, error) {
+		log.Println("Verifying ", formatRunDir(run))
+		return verifyRun(run)
+	}, func(r *runResult) error {
+		report := r.output.(*verifyReport)
+		fmt.Println(report)
+		if n := report.total(); n != 0 {
+			return fmt.Errorf("%d integrity issues", n)
+		}
+		return nil
+	})
+	return failedRunsError(failed, len(runs))
+}